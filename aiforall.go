@@ -1,52 +1,121 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/monochromegane/afa/internal/cache"
+	"github.com/monochromegane/afa/internal/llm"
+	"github.com/monochromegane/afa/internal/protocol"
+	"github.com/monochromegane/afa/internal/trace"
+	"github.com/monochromegane/afa/pkg/afa"
 	"golang.org/x/term"
 )
 
 type AIForAll struct {
-	WorkSpace *WorkSpace
+	WorkSpace *afa.WorkSpace
 	Input     io.Reader
 	Output    io.Writer
-	Option    *Option
+	Option    *afa.Option
 
 	SessionName  string
 	Message      string
 	MessageStdin string
 	Files        []string
+
+	Action string
+	Args   []string
+
+	SchemaExample string
+	SchemaSpec    string
+
+	Detach   bool
+	detached bool
+
+	NoCache bool
+	Refresh bool
 }
 
-func NewAIForAll(configDir, cacheDir string) (*AIForAll, error) {
-	workSpace := NewWorkSpace(configDir, cacheDir)
+// NewAIForAll hosts afa on the storage. Runtime files such as sockets are created in cacheDir on disk.
+func NewAIForAll(storage afa.Storage, cacheDir string) (*AIForAll, error) {
+	workSpace := afa.NewWorkSpaceWithStorage(storage, cacheDir)
 	option, err := workSpace.LoadOption()
 	if err != nil {
 		return nil, err
 	}
+	if err := workSpace.RegisterProviderPlugins(); err != nil {
+		return nil, err
+	}
 	if option.Chat.RunsOn == "" {
 		option.Chat.RunsOn = strconv.Itoa(os.Getppid())
 	}
-	return &AIForAll{
+	ai := &AIForAll{
 		WorkSpace: workSpace,
 		Input:     os.Stdin,
 		Output:    os.Stdout,
 		Option:    option,
-	}, nil
+	}
+	if option.Encryption.Enabled {
+		// The passphrase is asked when an encrypted file is read or written first.
+		workSpace.EnableEncryption(ai.encryptionSecret)
+	}
+	return ai, nil
+}
+
+// passphraseEnv is the passphrase of the encrypted workspace.
+const passphraseEnv = "AFA_PASSPHRASE"
+
+// encryptionSecret returns the content of the key file, or the passphrase from the environment variable or the terminal.
+func (ai *AIForAll) encryptionSecret() ([]byte, error) {
+	if keyFile := ai.Option.Encryption.KeyFile; keyFile != "" {
+		if strings.HasPrefix(keyFile, "~") {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			keyFile = strings.Replace(keyFile, "~", homeDir, 1)
+		}
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the key file: %v", err)
+		}
+		return bytes.TrimSpace(data), nil
+	}
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return nil, fmt.Errorf("Passphrase is required to decrypt the workspace. Please set %s or encryption.key_file in option.json.", passphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, "Enter passphrase: ")
+	passphrase, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read passphrase: %v", err)
+	}
+	return passphrase, nil
 }
 
 func (ai *AIForAll) Init() error {
 	var err error
 	var apiKey []byte
-	if ai.Option.Init.NoInteraction {
+	if ai.Option.Init.NoInteraction && !term.IsTerminal(int(syscall.Stdin)) {
+		// The API key is passed by a pipe. (e.g. pass show openai | afa init -n)
+		apiKey, err = io.ReadAll(ai.Input)
+		if err != nil {
+			return fmt.Errorf("Failed to read OpenAI API key: %v", err)
+		}
+		apiKey = bytes.TrimSpace(apiKey)
+	} else if ai.Option.Init.NoInteraction {
 		apiKey = []byte("")
 	} else {
 		fmt.Print("Enter your OpenAI API key: ")
@@ -55,39 +124,77 @@ func (ai *AIForAll) Init() error {
 			return fmt.Errorf("Failed to read OpenAI API key: %v", err)
 		}
 	}
-	return ai.WorkSpace.Setup(NewOption(), NewSecret(string(apiKey)))
+	return ai.WorkSpace.Setup(afa.NewOption(), afa.NewSecret(string(apiKey)))
 }
 
 func (ai *AIForAll) New() error {
-	ai.SessionName = ai.sessionNameFromTime(time.Now())
-	sessionPath := ai.WorkSpace.SessionPath(ai.SessionName)
+	ai.SessionName = ai.WorkSpace.NewSessionName(time.Now())
+	if name := os.Getenv(detachedSessionEnv); ai.Detach && name != "" {
+		// Runs as the background process started by StartDetached.
+		os.Unsetenv(detachedSessionEnv)
+		ai.SessionName = name
+		ai.detached = true
+		ai.Option.Chat.Save = true
+		ai.Option.Viewer.Enabled = false
+	}
+	sessionPath, err := ai.WorkSpace.SessionPath(ai.SessionName)
+	if err != nil {
+		return err
+	}
 	if err := ai.WorkSpace.SetupSession(sessionPath, ai.Option.Chat.Model, ai.Option.Chat.Schema); err != nil {
 		return err
 	}
-	return ai.startSession(sessionPath)
+	if err := ai.startSession(sessionPath); err != nil {
+		return err
+	}
+	if ai.detached {
+		logPath, err := ai.WorkSpace.DetachedLogPath(ai.SessionName)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 func (ai *AIForAll) Source() error {
-	sessionPath := ai.WorkSpace.SessionPath(ai.SessionName)
-	if _, err := os.Stat(sessionPath); os.IsNotExist(err) {
-		return fmt.Errorf("%s: no such session log", sessionPath)
+	sessionPath, err := ai.WorkSpace.SessionPath(ai.SessionName)
+	if err != nil {
+		return err
+	}
+	if !ai.WorkSpace.Exists(sessionPath) {
+		return &NotFoundError{Name: ai.WorkSpace.DisplayPath(sessionPath), Kind: "session log"}
 	}
 	return ai.startSession(sessionPath)
 }
 
 func (ai *AIForAll) Resume() error {
-	sidPath := ai.WorkSpace.SidPath(ai.Option.Chat.RunsOn)
-	if _, err := os.Stat(sidPath); os.IsNotExist(err) {
-		return fmt.Errorf("%s: no such sid", sidPath)
+	name, err := ai.latestSessionName()
+	if err != nil {
+		return err
 	}
+	ai.SessionName = name
+	return ai.Source()
+}
 
-	data, err := os.ReadFile(sidPath)
+// latestSessionName returns the name of the latest session that runs on the identifier.
+func (ai *AIForAll) latestSessionName() (string, error) {
+	sidPath, err := ai.WorkSpace.SidPath(ai.Option.Chat.RunsOn)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if !ai.WorkSpace.Exists(sidPath) {
+		return "", &NotFoundError{Name: ai.WorkSpace.DisplayPath(sidPath), Kind: "sid"}
+	}
+
+	data, err := ai.WorkSpace.ReadFile(sidPath)
+	if err != nil {
+		return "", err
 	}
 	lines := strings.Split(string(data), "\n")
-	ai.SessionName = lines[0]
-	return ai.Source()
+	return lines[0], nil
 }
 
 func (ai *AIForAll) List() error {
@@ -102,15 +209,25 @@ func (ai *AIForAll) List() error {
 }
 
 func (ai *AIForAll) Show() error {
-	sessionPath := ai.WorkSpace.SessionPath(ai.SessionName)
-	if _, err := os.Stat(sessionPath); os.IsNotExist(err) {
-		return fmt.Errorf("%s: no such session log", sessionPath)
+	sessionPath, err := ai.WorkSpace.SessionPath(ai.SessionName)
+	if err != nil {
+		return err
+	}
+	if !ai.WorkSpace.Exists(sessionPath) {
+		return &NotFoundError{Name: ai.WorkSpace.DisplayPath(sessionPath), Kind: "session log"}
 	}
 	history, err := ai.WorkSpace.LoadHistory(sessionPath)
 	if err != nil {
 		return err
 	}
-	_, output, viewer, err := ai.startViewer()
+	if ai.viewerEnabled() {
+		unlock, err := ai.lockSession()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	_, output, viewer, err := ai.startViewer(ai.sessionInfo(history))
 	if err != nil {
 		return err
 	}
@@ -126,6 +243,15 @@ func (ai *AIForAll) Show() error {
 }
 
 func (ai *AIForAll) startSession(sessionPath string) error {
+	// The session is locked only when it is served on the socket, which is named after it.
+	if ai.detached || ai.viewerEnabled() {
+		unlock, err := ai.lockSession()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
 	history, err := ai.WorkSpace.LoadHistory(sessionPath)
 	if err != nil {
 		return err
@@ -134,26 +260,97 @@ func (ai *AIForAll) startSession(sessionPath string) error {
 	if err != nil {
 		return err
 	}
-	input, output, viewer, err := ai.startViewer()
+	systemPromptTemplate, err := ai.WorkSpace.TemplatePath("system", ai.Option.Chat.SystemPromptTemplate)
+	if err != nil {
+		return err
+	}
+	userPromptTemplate, err := ai.WorkSpace.TemplatePath("user", ai.Option.Chat.UserPromptTemplate)
+	if err != nil {
+		return err
+	}
+	session, err := afa.NewSession(secret, history, ai.WorkSpace.Storage, afa.SessionOptions{
+		SystemPromptTemplate: systemPromptTemplate,
+		UserPromptTemplate:   userPromptTemplate,
+		Interactive:          ai.Option.Chat.Interactive,
+		Stream:               ai.Option.Chat.Stream,
+		WithHistory:          ai.Option.Chat.WithHistory,
+		DryRun:               ai.Option.Chat.DryRun,
+		MockRun:              ai.Option.Chat.MockRun,
+		Quote:                ai.Option.Chat.Quote,
+		RepairRetries:        ai.Option.Chat.RepairRetries,
+	})
+	if err != nil {
+		return err
+	}
+	if err := session.SetExtractPath(ai.Option.Chat.Extract); err != nil {
+		return err
+	}
+	if err := session.SetCodeSelector(ai.Option.Chat.Code, ai.Option.Chat.CodeWrite); err != nil {
+		return err
+	}
+	session.CodeOverwrite = ai.Option.Chat.CodeOverwrite
+	if err := ai.setCassette(session); err != nil {
+		return err
+	}
+	session.Hooks = afa.NewHooks(ai.Option.Hooks, ai.SessionName)
+	if session.Stream && !session.Hooks.Has(afa.HookPostResponse) && !session.StreamsResponses() {
+		fmt.Fprintln(os.Stderr, "Responses with the JSON schema are printed after the validation with -repair, so they are not streamed.")
+	}
+	if session.Redactor, err = afa.NewRedactor(ai.Option.Redact, os.Stderr); err != nil {
+		return &UsageError{err}
+	}
+	request, _, err := session.Hooks.Run(context.Background(), afa.HookSessionStart, session.History.Request, nil)
+	if err != nil {
+		return err
+	}
+	session.History.Request = request
+
+	// They are opened before the viewer, which has to be disconnected on every error after it starts.
+	tracer, err := ai.openTracer()
 	if err != nil {
 		return err
 	}
+	defer tracer.Close()
 
-	session := NewSession(
-		secret,
-		history,
-		ai.WorkSpace.TemplatePath("system", ai.Option.Chat.SystemPromptTemplate),
-		ai.WorkSpace.TemplatePath("user", ai.Option.Chat.UserPromptTemplate),
-		ai.Option.Chat.Interactive,
-		ai.Option.Chat.Stream,
-		ai.Option.Chat.WithHistory,
-		ai.Option.Chat.DryRun,
-		ai.Option.Chat.MockRun,
-		ai.Option.Chat.Quote,
-	)
-	err = session.Start(ai.Message, ai.MessageStdin, ai.Files, context.Background(), input, output)
+	responseCache, err := ai.openCache()
 	if err != nil {
-		if err := output.Error(); err != nil {
+		return err
+	}
+
+	input, output, viewer, err := ai.startViewer(ai.sessionInfo(history))
+	if err != nil {
+		return err
+	}
+
+	// End the session on "afa kill".
+	ctx, cancel := context.WithCancel(cache.NewContext(trace.NewContext(context.Background(), tracer), responseCache))
+	defer cancel()
+	terminated := make(chan os.Signal, 1)
+	signal.Notify(terminated, syscall.SIGTERM)
+	defer signal.Stop(terminated)
+	killed := make(chan struct{})
+	go func() {
+		select {
+		case <-terminated:
+			close(killed)
+			cancel()
+			output.Disconnect()
+		case <-ctx.Done():
+		}
+	}()
+
+	err = session.Start(ai.Message, ai.MessageStdin, ai.Files, ctx, input, output)
+	select {
+	case <-killed:
+		// A killed session ends normally, so that the turns so far are saved.
+		err = nil
+	default:
+	}
+	if err != nil {
+		if err := output.Error(err); err != nil {
+			return err
+		}
+		if err := output.Disconnect(); err != nil {
 			return err
 		}
 		if err := viewer.Wait(); err != nil {
@@ -171,55 +368,308 @@ func (ai *AIForAll) startSession(sessionPath string) error {
 	if session.History.FirstUserPrompt() == "" || !ai.Option.Chat.Save {
 		return ai.WorkSpace.RemoveSession(ai.SessionName)
 	}
+	request, _, err = session.Hooks.Run(context.WithoutCancel(ctx), afa.HookSessionSave, session.History.Request, nil)
+	if err != nil {
+		return err
+	}
+	session.History.Request = request
 	return ai.WorkSpace.SaveSession(ai.SessionName, ai.Option.Chat.RunsOn, session.History)
 }
 
-func (ai *AIForAll) startViewer() (MessageReader, MessageWriter, *Client, error) {
-	viewer := &Client{}
-	var input MessageReader = ai.Input
-	var output MessageWriter = &DefaultMessageWriter{ai.Output}
-	if ai.Option.Viewer.Enabled && len(ai.Option.Viewer.Command) > 0 {
-		connChan := make(chan net.Conn)
-		errChan := make(chan error)
-		socketPath := ai.WorkSpace.SocketPath(ai.SessionName)
-
-		// start server
-		server, err := NewServer(socketPath)
+func (ai *AIForAll) startViewer(info *protocol.SessionInfo) (afa.MessageReader, afa.MessageWriter, Viewer, error) {
+	var viewer Viewer = &Client{}
+	var input afa.MessageReader = ai.Input
+	var output afa.MessageWriter = &afa.DefaultMessageWriter{Writer: ai.Output}
+	if ai.detached {
+		// The session waits for viewers to attach.
+		server, err := ai.serve(info)
+		if err != nil {
+			return nil, nil, viewer, err
+		}
+		return server, server, viewer, nil
+	}
+	if ai.viewerEnabled() {
+		server, err := ai.serve(info)
 		if err != nil {
 			return nil, nil, viewer, err
 		}
-		server.Listen()
-		go func() {
-			conn, err := server.Accept()
-			if err != nil {
-				errChan <- err
-			}
-			connChan <- conn
-		}()
 
 		// start client as viewer
-		client, err := NewClient(socketPath, ai.Option.Viewer.Command)
+		client, err := ai.openViewer(server.Addr)
 		if err != nil {
+			server.Disconnect()
 			return nil, nil, viewer, err
 		}
-		if err := client.Start(); err != nil {
-			errChan <- err
-		}
 		viewer = client
 
-		// get conn
+		// wait for the viewer
 		select {
-		case conn := <-connChan:
-			input = NewSocketMessageReader(conn)
-			output = NewSocketMessageWriter(conn)
-		case err := <-errChan:
-			return nil, nil, viewer, err
+		case <-server.Attached():
+			input = server
+			output = server
+		case <-client.Done():
+			server.Disconnect()
+			if err := client.Wait(); err != nil {
+				return nil, nil, &Client{}, fmt.Errorf("Viewer exited before attaching to the session. %v", err)
+			}
+			return nil, nil, &Client{}, fmt.Errorf("Viewer exited before attaching to the session.")
 		}
 	}
 
 	return input, output, viewer, nil
 }
 
-func (ai *AIForAll) sessionNameFromTime(startedAt time.Time) string {
-	return startedAt.Format("2006-01-02_15-04-05")
+// openTracer opens the trace file of -trace, or of the session when AFA_TRACE is enabled.
+// The tracer is nil when tracing is disabled. Traces are written in plain text, so AFA_TRACE is ignored with encryption.
+func (ai *AIForAll) openTracer() (*trace.Tracer, error) {
+	path := ai.Option.Chat.Trace
+	if ai.Option.Encryption.Enabled {
+		if path != "" {
+			return nil, &UsageError{fmt.Errorf("-trace cannot be used with encryption, since traces are written in plain text.")}
+		}
+		return nil, nil
+	}
+	if path == "" {
+		switch env := os.Getenv("AFA_TRACE"); env {
+		case "", "0", "false":
+			return nil, nil
+		case "1", "true":
+			if err := ai.WorkSpace.MkDirAllIfNotExist(ai.WorkSpace.TraceDir()); err != nil {
+				return nil, err
+			}
+			tracePath, err := ai.WorkSpace.TracePath(ai.SessionName)
+			if err != nil {
+				return nil, err
+			}
+			path = tracePath
+		default:
+			path = env
+		}
+	}
+	return trace.Open(path)
+}
+
+// setCassette records the exchanges of the session with -record, or replays them with -replay.
+func (ai *AIForAll) setCassette(session *afa.Session) error {
+	record, replay := ai.Option.Chat.Record, ai.Option.Chat.Replay
+	switch {
+	case record != "" && replay != "":
+		return &UsageError{fmt.Errorf("-record and -replay cannot be used together.")}
+	case record != "":
+		session.Client = llm.NewRecorder(session.Client, record)
+	case replay != "":
+		cassette, err := llm.LoadCassette(replay)
+		if err != nil {
+			return err
+		}
+		session.Client = llm.NewReplayer(cassette, ai.Option.Chat.ReplaySpeed)
+	}
+	return nil
+}
+
+// openCache returns the response cache, or nil when it is disabled.
+func (ai *AIForAll) openCache() (*cache.Cache, error) {
+	if !ai.cacheEnabled() || ai.NoCache {
+		return nil, nil
+	}
+	responseCache, err := ai.responseCache()
+	if err != nil {
+		return nil, err
+	}
+	responseCache.Refresh = ai.Refresh
+	// The session commits the response after the validation.
+	responseCache.Defer = true
+	return responseCache, nil
+}
+
+// cacheEnabled reports whether responses are cached. Responses are cached in plain text, so the cache is disabled with encryption.
+func (ai *AIForAll) cacheEnabled() bool {
+	return ai.Option.Cache.Enabled && !ai.Option.Encryption.Enabled
+}
+
+func (ai *AIForAll) responseCache() (*cache.Cache, error) {
+	var ttl time.Duration
+	if ai.Option.Cache.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(ai.Option.Cache.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cache TTL %q. %v", ai.Option.Cache.TTL, err)
+		}
+	}
+	maxBytes := int64(ai.Option.Cache.MaxSizeMB) * 1024 * 1024
+	return cache.New(ai.WorkSpace.ResponseCacheDir(), ttl, maxBytes), nil
+}
+
+// Cache manages the response cache.
+func (ai *AIForAll) Cache() error {
+	switch ai.Action {
+	case "", "stats":
+		return ai.cacheStats()
+	case "clear":
+		return ai.cacheClear()
+	default:
+		return &UsageError{fmt.Errorf("Unknown action %q. Please provide one of the following actions: stats, clear.", ai.Action)}
+	}
+}
+
+func (ai *AIForAll) Encryption() error {
+	switch ai.Action {
+	case "", "status":
+		return ai.encryptionStatus()
+	case "encrypt":
+		names, err := ai.WorkSpace.EncryptFiles()
+		return ai.printEncryptionFiles("encrypted", names, err)
+	case "decrypt":
+		names, err := ai.WorkSpace.DecryptFiles()
+		return ai.printEncryptionFiles("decrypted", names, err)
+	default:
+		return &UsageError{fmt.Errorf("Unknown action %q. Please provide one of the following actions: status, encrypt, decrypt.", ai.Action)}
+	}
+}
+
+func (ai *AIForAll) encryptionStatus() error {
+	encrypted, plain, err := ai.WorkSpace.EncryptionFiles()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ai.Output, "enabled: %t\n", ai.Option.Encryption.Enabled)
+	fmt.Fprintf(ai.Output, "encrypted: %d\n", len(encrypted))
+	fmt.Fprintf(ai.Output, "plain: %d\n", len(plain))
+	return nil
+}
+
+func (ai *AIForAll) printEncryptionFiles(verb string, names []string, err error) error {
+	if errors.Is(err, afa.ErrEncryptionDisabled) {
+		return &UsageError{err}
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintf(ai.Output, "%s: %s\n", verb, ai.WorkSpace.DisplayPath(name))
+	}
+	return nil
+}
+
+func (ai *AIForAll) cacheStats() error {
+	responseCache, err := ai.responseCache()
+	if err != nil {
+		return err
+	}
+	stats, err := responseCache.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ai.Output, "enabled: %t\n", ai.cacheEnabled())
+	fmt.Fprintf(ai.Output, "entries: %d\n", stats.Entries)
+	fmt.Fprintf(ai.Output, "bytes: %d\n", stats.Bytes)
+	fmt.Fprintf(ai.Output, "hits: %d\n", stats.Hits)
+	fmt.Fprintf(ai.Output, "misses: %d\n", stats.Misses)
+	return nil
+}
+
+func (ai *AIForAll) cacheClear() error {
+	responseCache, err := ai.responseCache()
+	if err != nil {
+		return err
+	}
+	return responseCache.Clear()
+}
+
+// lockSession ensures that no other process runs the session.
+func (ai *AIForAll) lockSession() (func(), error) {
+	processPath, err := ai.WorkSpace.ProcessPath(ai.SessionName)
+	if err != nil {
+		return nil, err
+	}
+	socketPath, err := ai.WorkSpace.SocketPath(ai.SessionName)
+	if err != nil {
+		return nil, err
+	}
+	if err := ai.WorkSpace.LockSession(ai.SessionName, afa.NewSessionProcess(socketPath, ai.detached)); err != nil {
+		return nil, err
+	}
+	return func() { os.Remove(processPath) }, nil
+}
+
+func (ai *AIForAll) serve(info *protocol.SessionInfo) (*Server, error) {
+	socketPath, err := ai.WorkSpace.SocketPath(ai.SessionName)
+	if err != nil {
+		return nil, err
+	}
+	server, err := NewServer(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	server.KeepAlive = ai.detached
+	if err := server.Listen(); err != nil {
+		return nil, err
+	}
+	if err := server.SessionInfo(info); err != nil {
+		server.Disconnect()
+		return nil, err
+	}
+	go server.Serve()
+	return server, nil
+}
+
+func (ai *AIForAll) viewerEnabled() bool {
+	if !ai.Option.Viewer.Enabled {
+		return false
+	}
+	return ai.Option.Viewer.Mode == afa.ViewerModeWeb || len(ai.Option.Viewer.Command) > 0
+}
+
+func (ai *AIForAll) openViewer(socketPath string) (Viewer, error) {
+	if ai.Option.Viewer.Mode == afa.ViewerModeWeb {
+		viewer := NewWebViewer(ai.Option.Viewer.WebListen, os.Stderr)
+		if err := viewer.Start(socketPath); err != nil {
+			return nil, err
+		}
+		return viewer, nil
+	}
+
+	client, err := NewClient(socketPath, ai.Option.Viewer.Command)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Start(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (ai *AIForAll) Attach() error {
+	process, err := ai.WorkSpace.LoadProcess(ai.SessionName)
+	if err != nil || process.Socket == "" || !process.Alive() {
+		return &NotFoundError{Name: ai.SessionName, Kind: "running session"}
+	}
+	socketPath := process.Socket
+
+	if ai.viewerEnabled() {
+		viewer, err := ai.openViewer(socketPath)
+		if err != nil {
+			return err
+		}
+		return viewer.Wait()
+	}
+
+	viewer := &TerminalViewer{
+		Input:  ai.Input,
+		Output: ai.Output,
+	}
+	return viewer.Attach(socketPath)
+}
+
+func (ai *AIForAll) sessionInfo(history *afa.History) *protocol.SessionInfo {
+	info := &protocol.SessionInfo{
+		Name:        ai.SessionName,
+		Model:       history.Model,
+		Interactive: ai.Option.Chat.Interactive,
+		Stream:      ai.Option.Chat.Stream,
+	}
+	if history.JsonSchema != nil {
+		info.Schema = history.JsonSchema.Name
+	}
+	return info
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/monochromegane/afa/internal/payload"
+	"github.com/monochromegane/afa/internal/protocol"
+	"github.com/monochromegane/afa/pkg/afa"
+)
+
+func TestStartViewer(t *testing.T) {
+	tests := []struct {
+		name   string
+		output func(afa.MessageWriter) error
+		events []*protocol.Event
+	}{
+		{
+			name:   "chunk",
+			output: func(w afa.MessageWriter) error { _, err := w.Write([]byte("Hello")); return err },
+			events: []*protocol.Event{{Type: protocol.EventChunk, Content: "Hello"}},
+		},
+		{
+			name:   "prompt",
+			output: func(w afa.MessageWriter) error { return w.Prompt() },
+			events: []*protocol.Event{{Type: protocol.EventPrompt}},
+		},
+		{
+			name: "message",
+			output: func(w afa.MessageWriter) error {
+				if err := w.MessageStart("assistant"); err != nil {
+					return err
+				}
+				if _, err := w.Write([]byte("Hello")); err != nil {
+					return err
+				}
+				return w.MessageEnd("assistant")
+			},
+			events: []*protocol.Event{
+				{Type: protocol.EventMessageStart, Role: "assistant"},
+				{Type: protocol.EventChunk, Content: "Hello"},
+				{Type: protocol.EventMessageEnd, Role: "assistant"},
+			},
+		},
+		{
+			name:   "usage",
+			output: func(w afa.MessageWriter) error { return w.Usage(&payload.Usage{TotalTokens: 5}) },
+			events: []*protocol.Event{{Type: protocol.EventUsage, Usage: &payload.Usage{TotalTokens: 5}}},
+		},
+		{
+			name:   "error",
+			output: func(w afa.MessageWriter) error { return w.Error(errors.New("failed")) },
+			events: []*protocol.Event{{Type: protocol.EventError, Message: "failed"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A short path, since the length of socket paths is limited.
+			dir, err := os.MkdirTemp("", "afa")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			ai := &AIForAll{
+				WorkSpace:   afa.NewWorkSpace(dir, dir),
+				Option:      afa.NewOption(),
+				SessionName: "session",
+				detached:    true,
+			}
+			if err := os.MkdirAll(ai.WorkSpace.SocketDir(), 0o700); err != nil {
+				t.Fatal(err)
+			}
+
+			info := &protocol.SessionInfo{Name: "session", Model: "model", Interactive: true}
+			input, output, _, err := ai.startViewer(info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer output.Disconnect()
+
+			conn, err := net.Dial("unix", filepath.Join(ai.WorkSpace.SocketDir(), "session.sock"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := protocol.Handshake(conn); err != nil {
+				t.Fatal(err)
+			}
+			want := []*protocol.Event{
+				{Type: protocol.EventSessionInfo, Session: info},
+				{Type: protocol.EventInputControl, Granted: true},
+			}
+			for _, event := range want {
+				expectEvent(t, conn, event)
+			}
+
+			if err := tt.output(output); err != nil {
+				t.Fatal(err)
+			}
+			for _, event := range tt.events {
+				expectEvent(t, conn, event)
+			}
+
+			if err := protocol.WriteEvent(conn, &protocol.Event{Type: protocol.EventInput, Content: "from viewer"}); err != nil {
+				t.Fatal(err)
+			}
+			scanner := bufio.NewScanner(input)
+			if !scanner.Scan() || scanner.Text() != "from viewer" {
+				t.Errorf("input = %q, want %q", scanner.Text(), "from viewer")
+			}
+		})
+	}
+}
+
+func expectEvent(t *testing.T, conn net.Conn, want *protocol.Event) {
+	t.Helper()
+	got, err := protocol.ReadEvent(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != want.Type || got.Role != want.Role || got.Content != want.Content || got.Message != want.Message || got.Granted != want.Granted {
+		t.Errorf("event = %+v, want %+v", got, want)
+	}
+	if (got.Usage == nil) != (want.Usage == nil) || (got.Usage != nil && *got.Usage != *want.Usage) {
+		t.Errorf("usage = %+v, want %+v", got.Usage, want.Usage)
+	}
+	if (got.Session == nil) != (want.Session == nil) || (got.Session != nil && *got.Session != *want.Session) {
+		t.Errorf("session = %+v, want %+v", got.Session, want.Session)
+	}
+}
+
+func TestEncryptionDisablesPlainTextFiles(t *testing.T) {
+	t.Setenv("AFA_TRACE", "1")
+	dir := t.TempDir()
+	ai := &AIForAll{
+		WorkSpace:   afa.NewWorkSpace(dir, dir),
+		Option:      afa.NewOption(),
+		SessionName: "session",
+	}
+	ai.Option.Cache.Enabled = true
+	ai.Option.Encryption.Enabled = true
+
+	if responseCache, err := ai.openCache(); err != nil || responseCache != nil {
+		t.Errorf("openCache() = %v, %v, want the cache disabled with encryption", responseCache, err)
+	}
+	if tracer, err := ai.openTracer(); err != nil || tracer != nil {
+		t.Errorf("openTracer() = %v, %v, want AFA_TRACE ignored with encryption", tracer, err)
+	}
+	ai.Option.Chat.Trace = filepath.Join(dir, "trace.jsonl")
+	var usageErr *UsageError
+	if _, err := ai.openTracer(); !errors.As(err, &usageErr) {
+		t.Errorf("openTracer() error = %v, want UsageError for -trace with encryption", err)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/monochromegane/afa/pkg/afa"
 )
 
 type Command interface {
@@ -41,6 +43,7 @@ func (c *InitCommand) Run() error {
 type NewCommand struct {
 	flagSet  *flag.FlagSet
 	aiForAll *AIForAll
+	args     []string
 }
 
 func (c NewCommand) Name() string { return "new" }
@@ -50,7 +53,8 @@ func (c NewCommand) Description() string { return "Initiates a new session." }
 func (c NewCommand) Default() bool { return true }
 
 func (c *NewCommand) Parse(args []string) error {
-	if err := c.flagSet.Parse(args); err != nil {
+	c.args = args
+	if err := c.flagSet.Parse(normalizeViewerArgs(args)); err != nil {
 		return err
 	}
 	c.aiForAll.Files = c.flagSet.Args()
@@ -78,8 +82,13 @@ func (c *NewCommand) Parse(args []string) error {
 }
 
 func (c *NewCommand) Run() error {
-	if c.aiForAll.WorkSpace.IsNotExist() {
-		return workSpaceNotExistError()
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
+	}
+	if c.aiForAll.Detach && os.Getenv(detachedSessionEnv) == "" {
+		// The background process resumes on the same identifier as this one.
+		args := append([]string{c.Name(), "-R=" + c.aiForAll.Option.Chat.RunsOn}, c.args...)
+		return c.aiForAll.StartDetached(args)
 	}
 	return c.aiForAll.New()
 }
@@ -96,7 +105,7 @@ func (c SourceCommand) Description() string { return "Continue from a specified
 func (c SourceCommand) Default() bool { return false }
 
 func (c *SourceCommand) Parse(args []string) error {
-	if err := c.flagSet.Parse(args); err != nil {
+	if err := c.flagSet.Parse(normalizeViewerArgs(args)); err != nil {
 		return err
 	}
 	c.aiForAll.Files = c.flagSet.Args()
@@ -122,8 +131,8 @@ func (c *SourceCommand) Parse(args []string) error {
 }
 
 func (c *SourceCommand) Run() error {
-	if c.aiForAll.WorkSpace.IsNotExist() {
-		return workSpaceNotExistError()
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
 	}
 	return c.aiForAll.Source()
 }
@@ -140,7 +149,7 @@ func (c ResumeCommand) Description() string { return "Continue from the last ses
 func (c ResumeCommand) Default() bool { return false }
 
 func (c *ResumeCommand) Parse(args []string) error {
-	if err := c.flagSet.Parse(args); err != nil {
+	if err := c.flagSet.Parse(normalizeViewerArgs(args)); err != nil {
 		return err
 	}
 	c.aiForAll.Files = c.flagSet.Args()
@@ -166,8 +175,8 @@ func (c *ResumeCommand) Parse(args []string) error {
 }
 
 func (c *ResumeCommand) Run() error {
-	if c.aiForAll.WorkSpace.IsNotExist() {
-		return workSpaceNotExistError()
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
 	}
 	return c.aiForAll.Resume()
 }
@@ -203,15 +212,201 @@ func (c ShowCommand) Description() string { return "Show a specified session." }
 func (c ShowCommand) Default() bool { return false }
 
 func (c *ShowCommand) Parse(args []string) error {
-	return c.flagSet.Parse(args)
+	return c.flagSet.Parse(normalizeViewerArgs(args))
 }
 
 func (c *ShowCommand) Run() error {
 	return c.aiForAll.Show()
 }
 
+type AttachCommand struct {
+	flagSet  *flag.FlagSet
+	aiForAll *AIForAll
+}
+
+func (c AttachCommand) Name() string { return "attach" }
+
+func (c AttachCommand) Description() string { return "Attach to a running session." }
+
+func (c AttachCommand) Default() bool { return false }
+
+func (c *AttachCommand) Parse(args []string) error {
+	return c.flagSet.Parse(normalizeViewerArgs(args))
+}
+
+func (c *AttachCommand) Run() error {
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
+	}
+	return c.aiForAll.Attach()
+}
+
+type PsCommand struct {
+	flagSet  *flag.FlagSet
+	aiForAll *AIForAll
+}
+
+func (c PsCommand) Name() string { return "ps" }
+
+func (c PsCommand) Description() string { return "List running sessions." }
+
+func (c PsCommand) Default() bool { return false }
+
+func (c *PsCommand) Parse(args []string) error {
+	return c.flagSet.Parse(args)
+}
+
+func (c *PsCommand) Run() error {
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
+	}
+	return c.aiForAll.Ps()
+}
+
+type KillCommand struct {
+	flagSet  *flag.FlagSet
+	aiForAll *AIForAll
+}
+
+func (c KillCommand) Name() string { return "kill" }
+
+func (c KillCommand) Description() string { return "End a running session." }
+
+func (c KillCommand) Default() bool { return false }
+
+func (c *KillCommand) Parse(args []string) error {
+	return c.flagSet.Parse(args)
+}
+
+func (c *KillCommand) Run() error {
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
+	}
+	return c.aiForAll.Kill()
+}
+
+type ServeCommand struct {
+	flagSet  *flag.FlagSet
+	aiForAll *AIForAll
+}
+
+func (c ServeCommand) Name() string { return "serve" }
+
+func (c ServeCommand) Description() string {
+	return "Serve sessions, templates and schemas over HTTP."
+}
+
+func (c ServeCommand) Default() bool { return false }
+
+func (c *ServeCommand) Parse(args []string) error {
+	return c.flagSet.Parse(args)
+}
+
+func (c *ServeCommand) Run() error {
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
+	}
+	return c.aiForAll.Serve()
+}
+
+type TemplatesCommand struct {
+	flagSet  *flag.FlagSet
+	aiForAll *AIForAll
+}
+
+func (c TemplatesCommand) Name() string { return "templates" }
+
+func (c TemplatesCommand) Description() string {
+	return "Manage prompt templates named ROLE/NAME. (list|show|new|copy|delete|validate)"
+}
+
+func (c TemplatesCommand) Default() bool { return false }
+
+func (c *TemplatesCommand) Parse(args []string) error {
+	return parseActionArgs(c.flagSet, c.aiForAll, args)
+}
+
+func (c *TemplatesCommand) Run() error {
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
+	}
+	return c.aiForAll.Templates()
+}
+
+type SchemasCommand struct {
+	flagSet  *flag.FlagSet
+	aiForAll *AIForAll
+}
+
+func (c SchemasCommand) Name() string { return "schemas" }
+
+func (c SchemasCommand) Description() string {
+	return "Manage JSON schemas. (list|show|new|copy|delete|validate)"
+}
+
+func (c SchemasCommand) Default() bool { return false }
+
+func (c *SchemasCommand) Parse(args []string) error {
+	return parseActionArgs(c.flagSet, c.aiForAll, args)
+}
+
+func (c *SchemasCommand) Run() error {
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
+	}
+	return c.aiForAll.Schemas()
+}
+
+type CacheCommand struct {
+	flagSet  *flag.FlagSet
+	aiForAll *AIForAll
+}
+
+func (c CacheCommand) Name() string { return "cache" }
+
+func (c CacheCommand) Description() string {
+	return "Manage the response cache. (stats|clear)"
+}
+
+func (c CacheCommand) Default() bool { return false }
+
+func (c *CacheCommand) Parse(args []string) error {
+	return parseActionArgs(c.flagSet, c.aiForAll, args)
+}
+
+func (c *CacheCommand) Run() error {
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
+	}
+	return c.aiForAll.Cache()
+}
+
+type EncryptionCommand struct {
+	flagSet  *flag.FlagSet
+	aiForAll *AIForAll
+}
+
+func (c EncryptionCommand) Name() string { return "encryption" }
+
+func (c EncryptionCommand) Description() string {
+	return "Manage the encryption of the secret and sessions. (status|encrypt|decrypt)"
+}
+
+func (c EncryptionCommand) Default() bool { return false }
+
+func (c *EncryptionCommand) Parse(args []string) error {
+	return parseActionArgs(c.flagSet, c.aiForAll, args)
+}
+
+func (c *EncryptionCommand) Run() error {
+	if err := checkWorkSpace(c.aiForAll.WorkSpace); err != nil {
+		return err
+	}
+	return c.aiForAll.Encryption()
+}
+
 func GetInitCommand() (Command, error) {
-	flagSet := flag.NewFlagSet("init", flag.ExitOnError)
+	flagSet := flag.NewFlagSet("init", flag.ContinueOnError)
 	aiForAll, err := newAIForAll()
 	if err != nil {
 		return nil, err
@@ -230,7 +425,7 @@ func GetInitCommand() (Command, error) {
 }
 
 func GetNewCommand() (Command, error) {
-	flagSet := flag.NewFlagSet(fmt.Sprintf("%s new", cmdName), flag.ExitOnError)
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s new", cmdName), flag.ContinueOnError)
 	aiForAll, err := newAIForAll()
 	if err != nil {
 		return nil, err
@@ -243,6 +438,12 @@ func GetNewCommand() (Command, error) {
 		return nil, err
 	}
 
+	flagSet.BoolVar(
+		&aiForAll.Detach,
+		"d",
+		aiForAll.Detach,
+		"Runs the session in the background. Attach with \"afa attach -l NAME\".",
+	)
 	flagSet.StringVar(
 		&aiForAll.Option.Chat.SystemPromptTemplate,
 		"s",
@@ -253,7 +454,7 @@ func GetNewCommand() (Command, error) {
 		&aiForAll.Option.Chat.Model,
 		"m",
 		aiForAll.Option.Chat.Model,
-		"Name of Model.",
+		"Name of Model. (PROVIDER/MODEL for a provider other than openai)",
 	)
 	flagSet.StringVar(
 		&aiForAll.Option.Chat.Schema,
@@ -281,7 +482,7 @@ func GetNewCommand() (Command, error) {
 }
 
 func GetSourceCommand() (Command, error) {
-	flagSet := flag.NewFlagSet(fmt.Sprintf("%s source", cmdName), flag.ExitOnError)
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s source", cmdName), flag.ContinueOnError)
 	aiForAll, err := newAIForAll()
 	if err != nil {
 		return nil, err
@@ -320,7 +521,7 @@ func GetSourceCommand() (Command, error) {
 }
 
 func GetResumeCommand() (Command, error) {
-	flagSet := flag.NewFlagSet(fmt.Sprintf("%s resume", cmdName), flag.ExitOnError)
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s resume", cmdName), flag.ContinueOnError)
 	aiForAll, err := newAIForAll()
 	if err != nil {
 		return nil, err
@@ -352,7 +553,7 @@ func GetResumeCommand() (Command, error) {
 }
 
 func GetListCommand() (Command, error) {
-	flagSet := flag.NewFlagSet(fmt.Sprintf("%s list", cmdName), flag.ExitOnError)
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s list", cmdName), flag.ContinueOnError)
 	aiForAll, err := newAIForAll()
 	if err != nil {
 		return nil, err
@@ -378,7 +579,7 @@ func GetListCommand() (Command, error) {
 }
 
 func GetShowCommand() (Command, error) {
-	flagSet := flag.NewFlagSet(fmt.Sprintf("%s show", cmdName), flag.ExitOnError)
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s show", cmdName), flag.ContinueOnError)
 	aiForAll, err := newAIForAll()
 	if err != nil {
 		return nil, err
@@ -400,6 +601,121 @@ func GetShowCommand() (Command, error) {
 	}, nil
 }
 
+func GetAttachCommand() (Command, error) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s attach", cmdName), flag.ContinueOnError)
+	aiForAll, err := newAIForAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setBasicViewerFlags(aiForAll, flagSet); err != nil {
+		return nil, err
+	}
+	flagSet.StringVar(
+		&aiForAll.SessionName,
+		"l",
+		aiForAll.SessionName,
+		"Log name of session.",
+	)
+
+	return &AttachCommand{
+		flagSet:  flagSet,
+		aiForAll: aiForAll,
+	}, nil
+}
+
+func GetPsCommand() (Command, error) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s ps", cmdName), flag.ContinueOnError)
+	aiForAll, err := newAIForAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PsCommand{
+		flagSet:  flagSet,
+		aiForAll: aiForAll,
+	}, nil
+}
+
+func GetKillCommand() (Command, error) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s kill", cmdName), flag.ContinueOnError)
+	aiForAll, err := newAIForAll()
+	if err != nil {
+		return nil, err
+	}
+
+	flagSet.StringVar(
+		&aiForAll.SessionName,
+		"l",
+		aiForAll.SessionName,
+		"Log name of session.",
+	)
+
+	return &KillCommand{
+		flagSet:  flagSet,
+		aiForAll: aiForAll,
+	}, nil
+}
+
+func GetServeCommand() (Command, error) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s serve", cmdName), flag.ContinueOnError)
+	aiForAll, err := newAIForAll()
+	if err != nil {
+		return nil, err
+	}
+
+	flagSet.StringVar(
+		&aiForAll.Option.Serve.Listen,
+		"listen",
+		aiForAll.Option.Serve.Listen,
+		"Address to listen on. (HOST:PORT or unix:PATH)",
+	)
+
+	return &ServeCommand{
+		flagSet:  flagSet,
+		aiForAll: aiForAll,
+	}, nil
+}
+
+func GetTemplatesCommand() (Command, error) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s templates", cmdName), flag.ContinueOnError)
+	aiForAll, err := newAIForAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TemplatesCommand{
+		flagSet:  flagSet,
+		aiForAll: aiForAll,
+	}, nil
+}
+
+func GetSchemasCommand() (Command, error) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s schemas", cmdName), flag.ContinueOnError)
+	aiForAll, err := newAIForAll()
+	if err != nil {
+		return nil, err
+	}
+
+	flagSet.StringVar(
+		&aiForAll.SchemaExample,
+		"from-example",
+		aiForAll.SchemaExample,
+		"Generate the schema from an example JSON file on new. (\"-\" for standard input)",
+	)
+	flagSet.StringVar(
+		&aiForAll.SchemaSpec,
+		"from-spec",
+		aiForAll.SchemaSpec,
+		"Generate the schema from type declarations on new. (e.g. \"command:string, files:[string]\")",
+	)
+
+	return &SchemasCommand{
+		flagSet:  flagSet,
+		aiForAll: aiForAll,
+	}, nil
+}
+
 func setBasicChatFlags(aiForAll *AIForAll, flagSet *flag.FlagSet) error {
 	flagSet.BoolVar(
 		&aiForAll.Option.Script.Enabled,
@@ -443,23 +759,215 @@ func setBasicChatFlags(aiForAll *AIForAll, flagSet *flag.FlagSet) error {
 		aiForAll.Option.Chat.Quote,
 		"Wraps the output in double quotes, safely escaped for valid string literals.",
 	)
+	flagSet.IntVar(
+		&aiForAll.Option.Chat.RepairRetries,
+		"repair",
+		aiForAll.Option.Chat.RepairRetries,
+		"Number of retries that feed JSON schema validation errors back to the model.",
+	)
+	flagSet.StringVar(
+		&aiForAll.Option.Chat.Extract,
+		"x",
+		aiForAll.Option.Chat.Extract,
+		"Path of the field to extract from the structured output. (e.g. \".suggested_command\")",
+	)
+	flagSet.Var(
+		&codeFlag{&aiForAll.Option.Chat.Code},
+		"code",
+		"Outputs only fenced code blocks in the response. Selects by index, language or all. (e.g. -code, -code=1, -code=go, -code=all)",
+	)
+	flagSet.BoolVar(
+		&aiForAll.Option.Chat.CodeWrite,
+		"code-write",
+		aiForAll.Option.Chat.CodeWrite,
+		"Writes each selected code block to the file named in its fence info string. (e.g. \"go main.go\")",
+	)
+	flagSet.BoolVar(
+		&aiForAll.Option.Chat.CodeOverwrite,
+		"code-overwrite",
+		aiForAll.Option.Chat.CodeOverwrite,
+		"Allows -code-write to overwrite existing files.",
+	)
+	flagSet.StringVar(
+		&aiForAll.Option.Chat.Trace,
+		"trace",
+		aiForAll.Option.Chat.Trace,
+		"Records HTTP exchanges with the provider to the JSONL file. (AFA_TRACE=1 records to the cache directory)",
+	)
+	flagSet.StringVar(
+		&aiForAll.Option.Redact.Mode,
+		"redact",
+		aiForAll.Option.Redact.Mode,
+		"Mode of the secret scanner for prompts. (off, warn, redact or block)",
+	)
+	flagSet.StringVar(
+		&aiForAll.Option.Chat.Record,
+		"record",
+		aiForAll.Option.Chat.Record,
+		"Records requests and responses with the provider to the cassette file.",
+	)
+	flagSet.StringVar(
+		&aiForAll.Option.Chat.Replay,
+		"replay",
+		aiForAll.Option.Chat.Replay,
+		"Replays responses from the cassette file instead of the provider.",
+	)
+	flagSet.Float64Var(
+		&aiForAll.Option.Chat.ReplaySpeed,
+		"replay-speed",
+		aiForAll.Option.Chat.ReplaySpeed,
+		"Speed of the recorded delays between stream chunks on replay. 0 replays without delays.",
+	)
+	flagSet.BoolVar(
+		&aiForAll.NoCache,
+		"no-cache",
+		aiForAll.NoCache,
+		"Does not use the response cache.",
+	)
+	flagSet.BoolVar(
+		&aiForAll.Refresh,
+		"refresh",
+		aiForAll.Refresh,
+		"Ignores cached responses, and stores new ones.",
+	)
 
 	return nil
 }
 
 func setBasicViewerFlags(aiForAll *AIForAll, flagSet *flag.FlagSet) error {
-	flagSet.BoolVar(
-		&aiForAll.Option.Viewer.Enabled,
+	flagSet.Var(
+		&viewerFlag{aiForAll.Option.Viewer},
 		"V",
-		aiForAll.Option.Viewer.Enabled,
 		fmt.Sprintf(
-			"Use the viewer program. (\"%s $SOCK_ADDR\")",
+			"Use the viewer program (\"%s $SOCK_ADDR\"), or -V=web for the viewer in the browser.",
 			strings.Join(aiForAll.Option.Viewer.Command, " "),
 		),
 	)
 	return nil
 }
 
+func GetCacheCommand() (Command, error) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s cache", cmdName), flag.ContinueOnError)
+	aiForAll, err := newAIForAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CacheCommand{
+		flagSet:  flagSet,
+		aiForAll: aiForAll,
+	}, nil
+}
+
+func GetEncryptionCommand() (Command, error) {
+	flagSet := flag.NewFlagSet(fmt.Sprintf("%s encryption", cmdName), flag.ContinueOnError)
+	aiForAll, err := newAIForAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptionCommand{
+		flagSet:  flagSet,
+		aiForAll: aiForAll,
+	}, nil
+}
+
+func parseActionArgs(flagSet *flag.FlagSet, aiForAll *AIForAll, args []string) error {
+	// Flags are allowed after the action and its arguments. (e.g. "new NAME -from-spec SPEC")
+	positionals := []string{}
+	for {
+		if err := flagSet.Parse(args); err != nil {
+			return err
+		}
+		if flagSet.NArg() == 0 {
+			break
+		}
+		positionals = append(positionals, flagSet.Arg(0))
+		args = flagSet.Args()[1:]
+	}
+	if len(positionals) > 0 {
+		aiForAll.Action = positionals[0]
+		aiForAll.Args = positionals[1:]
+	}
+	return nil
+}
+
+// codeFlag is a string flag that can also be used as a boolean flag to select the first code block.
+type codeFlag struct {
+	value *string
+}
+
+func (f *codeFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f *codeFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*f.value = "0"
+	case "false":
+		*f.value = ""
+	default:
+		*f.value = value
+	}
+	return nil
+}
+
+func (f *codeFlag) IsBoolFlag() bool { return true }
+
+// viewerFlag is a boolean flag that can also select the viewer mode. (e.g. -V, -V=web)
+type viewerFlag struct {
+	option *afa.ViewerOption
+}
+
+func (f *viewerFlag) String() string {
+	if f.option == nil || !f.option.Enabled {
+		return "false"
+	}
+	if f.option.Mode == afa.ViewerModeWeb {
+		return afa.ViewerModeWeb
+	}
+	return "true"
+}
+
+func (f *viewerFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.option.Enabled = true
+	case "false":
+		f.option.Enabled = false
+	case afa.ViewerModeCommand, afa.ViewerModeWeb:
+		f.option.Enabled = true
+		f.option.Mode = value
+	default:
+		return fmt.Errorf("Unknown viewer mode %q. (true, false, %s or %s)", value, afa.ViewerModeCommand, afa.ViewerModeWeb)
+	}
+	return nil
+}
+
+func (f *viewerFlag) IsBoolFlag() bool { return true }
+
+// normalizeViewerArgs joins the viewer mode given as a separate argument. (e.g. "-V web" to "-V=web")
+func normalizeViewerArgs(args []string) []string {
+	normalized := []string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			return append(normalized, args[i:]...)
+		}
+		if (args[i] == "-V" || args[i] == "--V") && i+1 < len(args) &&
+			(args[i+1] == afa.ViewerModeWeb || args[i+1] == afa.ViewerModeCommand) {
+			normalized = append(normalized, args[i]+"="+args[i+1])
+			i++
+			continue
+		}
+		normalized = append(normalized, args[i])
+	}
+	return normalized
+}
+
 func hasStdin() bool {
 	if stat, err := os.Stdin.Stat(); err == nil {
 		return (stat.Mode() & os.ModeCharDevice) == 0
@@ -467,6 +975,17 @@ func hasStdin() bool {
 	return false
 }
 
+func checkWorkSpace(workSpace *afa.WorkSpace) error {
+	exists, err := workSpace.SetupIfKeyGiven()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return workSpaceNotExistError()
+	}
+	return nil
+}
+
 func workSpaceNotExistError() error {
 	return fmt.Errorf("No workspace exists. Please run \"afa init\".")
 }
@@ -492,10 +1011,9 @@ func newAIForAll() (*AIForAll, error) {
 		}
 	}
 
-	return NewAIForAll(
-		path.Join(configDir, "afa"),
-		path.Join(cacheDir, "afa"),
-	)
+	configDir = path.Join(configDir, "afa")
+	cacheDir = path.Join(cacheDir, "afa")
+	return NewAIForAll(afa.NewDiskStorage(configDir, cacheDir), cacheDir)
 }
 
 func getXdgHomeDir(env string) (string, error) {
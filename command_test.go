@@ -3,6 +3,8 @@ package main
 import (
 	"strings"
 	"testing"
+
+	"github.com/monochromegane/afa/pkg/afa"
 )
 
 func TestGetXdgHomeDirNoEnv(t *testing.T) {
@@ -24,3 +26,41 @@ func TestGetXdgHomeDir(t *testing.T) {
 		t.Errorf("getXdgHomeDir should replace tilde to user's home dir")
 	}
 }
+
+func TestNormalizeViewerArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-V", "web", "file"}, []string{"-V=web", "file"}},
+		{[]string{"-V", "file"}, []string{"-V", "file"}},
+		{[]string{"-p", "hi", "--V", "command"}, []string{"-p", "hi", "--V=command"}},
+		{[]string{"--", "-V", "web"}, []string{"--", "-V", "web"}},
+	}
+	for _, tt := range tests {
+		got := normalizeViewerArgs(tt.args)
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("normalizeViewerArgs(%v) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestViewerFlag(t *testing.T) {
+	option := &afa.ViewerOption{Mode: afa.ViewerModeCommand}
+	flag := &viewerFlag{option}
+	if err := flag.Set("web"); err != nil {
+		t.Fatal(err)
+	}
+	if !option.Enabled || option.Mode != afa.ViewerModeWeb {
+		t.Errorf("-V=web should enable the web viewer, got %+v", option)
+	}
+	if err := flag.Set("false"); err != nil {
+		t.Fatal(err)
+	}
+	if option.Enabled {
+		t.Errorf("-V=false should disable the viewer")
+	}
+	if err := flag.Set("tui"); err == nil {
+		t.Errorf("unknown viewer mode should return error")
+	}
+}
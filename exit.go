@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"strings"
+
+	"github.com/monochromegane/afa/internal/jsonpath"
+	"github.com/monochromegane/afa/internal/jsonschema"
+	"github.com/monochromegane/afa/internal/llm"
+	"github.com/monochromegane/afa/pkg/afa"
+)
+
+const (
+	exitCodeError           = 1
+	exitCodeInvalidResponse = 2
+	exitCodePathNotFound    = 3
+	exitCodeUsage           = 4
+	exitCodeNotFound        = 5
+	exitCodeAuth            = 6
+	exitCodeRateLimit       = 7
+	exitCodeContextLength   = 8
+	exitCodeRefusal         = 9
+	exitCodeNetwork         = 10
+	exitCodeServer          = 11
+	exitCodeRejected        = 12
+)
+
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+var llmExitCodes = map[llm.ErrorKind]int{
+	llm.KindAuth:           exitCodeAuth,
+	llm.KindRateLimit:      exitCodeRateLimit,
+	llm.KindContextLength:  exitCodeContextLength,
+	llm.KindRefusal:        exitCodeRefusal,
+	llm.KindNetwork:        exitCodeNetwork,
+	llm.KindServer:         exitCodeServer,
+	llm.KindInvalidRequest: exitCodeError,
+}
+
+type ErrorReport struct {
+	Error *ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Type       string              `json:"type"`
+	Message    string              `json:"message"`
+	ExitCode   int                 `json:"exit_code,omitempty"`
+	StatusCode int                 `json:"status_code,omitempty"`
+	Details    []*jsonschema.Error `json:"details,omitempty"`
+	Response   string              `json:"response,omitempty"`
+}
+
+// UsageError is returned when the command line is wrong.
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+// NotFoundError is returned when a session, template or schema does not exist.
+type NotFoundError struct {
+	Name string
+	Kind string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s: no such %s", e.Name, e.Kind)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return fs.ErrNotExist
+}
+
+// errorDetail classifies the error and returns its exit code.
+func errorDetail(err error) (*ErrorDetail, int) {
+	var verr *afa.ResponseValidationError
+	if errors.As(err, &verr) {
+		return &ErrorDetail{
+			Type:     "invalid_response",
+			Message:  "Response does not conform to the JSON schema.",
+			Details:  verr.Errors,
+			Response: verr.Response,
+		}, exitCodeInvalidResponse
+	}
+	var nerr *jsonpath.NotFoundError
+	if errors.As(err, &nerr) {
+		return &ErrorDetail{Type: "path_not_found", Message: nerr.Error()}, exitCodePathNotFound
+	}
+	var herr *afa.HookRejectedError
+	if errors.As(err, &herr) {
+		return &ErrorDetail{Type: "rejected", Message: herr.Error()}, exitCodeRejected
+	}
+	var serr *afa.SecretsFoundError
+	if errors.As(err, &serr) {
+		return &ErrorDetail{Type: "rejected", Message: serr.Error()}, exitCodeRejected
+	}
+	var ierr *afa.InvalidNameError
+	if errors.As(err, &ierr) {
+		return &ErrorDetail{Type: "usage", Message: err.Error()}, exitCodeUsage
+	}
+	var uerr *UsageError
+	if errors.As(err, &uerr) {
+		return &ErrorDetail{Type: "usage", Message: err.Error()}, exitCodeUsage
+	}
+	var lerr *llm.Error
+	if errors.As(err, &lerr) {
+		code, ok := llmExitCodes[lerr.Kind]
+		if !ok {
+			code = exitCodeError
+		}
+		return &ErrorDetail{Type: string(lerr.Kind), Message: err.Error(), StatusCode: lerr.StatusCode}, code
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return &ErrorDetail{Type: "not_found", Message: err.Error()}, exitCodeNotFound
+	}
+	return &ErrorDetail{Type: "error", Message: err.Error()}, exitCodeError
+}
+
+// reportError writes the error in the format and returns the exit code.
+func reportError(w io.Writer, format, prefix string, err error) int {
+	detail, code := errorDetail(err)
+	if format == errorFormatJSON {
+		detail.ExitCode = code
+		writeErrorReport(w, detail)
+		return code
+	}
+	logger := log.New(w, "", log.LstdFlags)
+	if prefix == "" {
+		logger.Print(fmt.Sprintf("Error: %v", err))
+	} else {
+		logger.Print(fmt.Sprintf("Error: %s %v", prefix, err))
+	}
+	return code
+}
+
+func writeErrorReport(w io.Writer, detail *ErrorDetail) {
+	data, err := json.Marshal(&ErrorReport{Error: detail})
+	if err != nil {
+		fmt.Fprintf(w, "%s\n", detail.Message)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+// extractErrorFormat removes the error format option from anywhere in the arguments,
+// since it applies to all subcommands. (e.g. "--error-format json", "-error-format=json")
+func extractErrorFormat(args []string) ([]string, string, error) {
+	format := errorFormatText
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "error-format" {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, format, &UsageError{fmt.Errorf("flag needs an argument: -error-format")}
+			}
+			i++
+			value = args[i]
+		}
+		if value != errorFormatText && value != errorFormatJSON {
+			return nil, format, &UsageError{fmt.Errorf("Unknown error format %q. (%s or %s)", value, errorFormatText, errorFormatJSON)}
+		}
+		format = value
+	}
+	return rest, format, nil
+}
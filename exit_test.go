@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExtractErrorFormat(t *testing.T) {
+	tests := []struct {
+		args   []string
+		rest   []string
+		format string
+	}{
+		{[]string{"new", "-p", "hi"}, []string{"new", "-p", "hi"}, errorFormatText},
+		{[]string{"--error-format", "json", "new"}, []string{"new"}, errorFormatJSON},
+		{[]string{"new", "-error-format=json", "file"}, []string{"new", "file"}, errorFormatJSON},
+		{[]string{"new", "--", "--error-format=json"}, []string{"new", "--", "--error-format=json"}, errorFormatText},
+	}
+	for _, tt := range tests {
+		rest, format, err := extractErrorFormat(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(rest, " ") != strings.Join(tt.rest, " ") || format != tt.format {
+			t.Errorf("extractErrorFormat(%v) = %v, %s, want %v, %s", tt.args, rest, format, tt.rest, tt.format)
+		}
+	}
+
+	_, _, err := extractErrorFormat([]string{"--error-format", "xml"})
+	var uerr *UsageError
+	if !errors.As(err, &uerr) {
+		t.Errorf("unknown error format should return UsageError, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/monochromegane/afa/pkg/afa"
+)
+
+// externalCommandPrefix is the prefix of the executables on PATH that run as subcommands. (e.g. afa-tui for "afa tui")
+const externalCommandPrefix = cmdName + "-"
+
+// Environment variables passed to external commands.
+const (
+	configDirEnv = "AFA_CONFIG_DIR"
+	cacheDirEnv  = "AFA_CACHE_DIR"
+	sessionEnv   = "AFA_SESSION"
+	socketEnv    = "AFA_SOCKET"
+)
+
+type ExternalCommand struct {
+	name     string
+	path     string
+	args     []string
+	aiForAll *AIForAll
+}
+
+func (c ExternalCommand) Name() string { return c.name }
+
+func (c ExternalCommand) Description() string { return externalCommandDescription(c.path) }
+
+func (c ExternalCommand) Default() bool { return false }
+
+// Parse passes the arguments to the external command as they are.
+func (c *ExternalCommand) Parse(args []string) error {
+	c.args = args
+	return nil
+}
+
+func (c *ExternalCommand) Run() error {
+	cmd := exec.Command(c.path, c.args...)
+	cmd.Env = append(os.Environ(), c.aiForAll.externalCommandEnv()...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func GetExternalCommand(name string) (Command, error) {
+	if !isExternalCommandName(name) {
+		return nil, &exec.Error{Name: externalCommandPrefix + name, Err: exec.ErrNotFound}
+	}
+	path, err := exec.LookPath(externalCommandPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	aiForAll, err := newAIForAll()
+	if err != nil {
+		return nil, err
+	}
+	return &ExternalCommand{
+		name:     name,
+		path:     path,
+		aiForAll: aiForAll,
+	}, nil
+}
+
+// findExternalCommands returns the paths of the external commands on PATH by name, except the subcommands.
+func findExternalCommands(subcommands []string) map[string]string {
+	commands := map[string]string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), externalCommandPrefix)
+			if !ok || !isExternalCommandName(name) || slices.Contains(subcommands, name) {
+				continue
+			}
+			if _, ok := commands[name]; ok {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if info, err := os.Stat(path); err != nil || info.IsDir() || info.Mode()&0o111 == 0 {
+				continue
+			}
+			commands[name] = path
+		}
+	}
+	return commands
+}
+
+func printExternalCommands(w io.Writer, subcommands []string) {
+	commands := findExternalCommands(subcommands)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n\t%s\n", name, externalCommandDescription(commands[name]))
+	}
+}
+
+func externalCommandDescription(path string) string {
+	return fmt.Sprintf("External command. (%s)", path)
+}
+
+// isExternalCommandName reports whether the name can be a subcommand. Provider plugins are not subcommands.
+func isExternalCommandName(name string) bool {
+	return name != "" &&
+		!strings.HasPrefix(name, "-") &&
+		!strings.ContainsAny(name, `/\`) &&
+		!strings.HasPrefix(externalCommandPrefix+name, afa.ProviderPluginPrefix)
+}
+
+// externalCommandEnv returns the directories of the workspace and the latest session for external commands.
+func (ai *AIForAll) externalCommandEnv() []string {
+	env := []string{fmt.Sprintf("%s=%s", cacheDirEnv, ai.WorkSpace.CacheDir)}
+	if storage, ok := ai.WorkSpace.DiskStorage(); ok {
+		env = append(env, fmt.Sprintf("%s=%s", configDirEnv, storage.ConfigDir))
+	}
+	session, socket := "", ""
+	if name, err := ai.latestSessionName(); err == nil {
+		if socketPath, err := ai.WorkSpace.SocketPath(name); err == nil {
+			session, socket = name, socketPath
+		}
+	}
+	return append(env, fmt.Sprintf("%s=%s", sessionEnv, session), fmt.Sprintf("%s=%s", socketEnv, socket))
+}
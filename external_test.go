@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindExternalCommands(t *testing.T) {
+	first := t.TempDir()
+	second := t.TempDir()
+	files := []struct {
+		dir  string
+		name string
+		perm os.FileMode
+	}{
+		{dir: first, name: "afa-tui", perm: 0o755},
+		{dir: second, name: "afa-tui", perm: 0o755},
+		{dir: second, name: "afa-stats", perm: 0o755},
+		{dir: second, name: "afa-noexec", perm: 0o644},
+		{dir: second, name: "afa-provider-gateway", perm: 0o755},
+		{dir: second, name: "afa-new", perm: 0o755},
+		{dir: second, name: "other", perm: 0o755},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(f.dir, f.name), []byte("#!/bin/sh\n"), f.perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", first+string(filepath.ListSeparator)+second)
+
+	commands := findExternalCommands([]string{"new"})
+	want := map[string]string{
+		"tui":   filepath.Join(first, "afa-tui"),
+		"stats": filepath.Join(second, "afa-stats"),
+	}
+	if len(commands) != len(want) {
+		t.Errorf("findExternalCommands() = %v, want %v", commands, want)
+	}
+	for name, path := range want {
+		if commands[name] != path {
+			t.Errorf("findExternalCommands()[%q] = %q, want %q", name, commands[name], path)
+		}
+	}
+}
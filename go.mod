@@ -2,6 +2,9 @@ module github.com/monochromegane/afa
 
 go 1.23.0
 
-require golang.org/x/term v0.24.0
+require (
+	golang.org/x/crypto v0.27.0
+	golang.org/x/term v0.24.0
+)
 
 require golang.org/x/sys v0.25.0 // indirect
@@ -0,0 +1,314 @@
+// Package cache stores responses of the provider keyed by a hash of the request.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+const (
+	entryExt  = ".json"
+	statsFile = "stats.json"
+)
+
+// Entry is a cached response. A streamed response is kept as its chunks, so that it can be replayed.
+type Entry struct {
+	CreatedAt time.Time          `json:"created_at"`
+	Chunks    []*payload.Message `json:"chunks"`
+	Usage     *payload.Usage     `json:"usage,omitempty"`
+}
+
+// Message joins the chunks into a message.
+func (e *Entry) Message() *payload.Message {
+	message := &payload.Message{}
+	var content strings.Builder
+	for _, chunk := range e.Chunks {
+		if chunk.Role != "" {
+			message.Role = chunk.Role
+		}
+		content.WriteString(chunk.Content)
+	}
+	message.Content = content.String()
+	return message
+}
+
+type Stats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Hits    int   `json:"hits"`
+	Misses  int   `json:"misses"`
+}
+
+type Cache struct {
+	Dir string
+	// TTL is the lifetime of entries. Zero means no expiration.
+	TTL time.Duration
+	// MaxBytes is the total size of entries. The oldest entries are removed beyond it. Zero means no limit.
+	MaxBytes int64
+	// Refresh ignores the entries, but stores the responses.
+	Refresh bool
+	// Defer keeps the entries of Put until Commit, so that only the responses accepted by the caller are stored.
+	Defer bool
+
+	mu      sync.Mutex
+	pending map[string]*Entry
+}
+
+func New(dir string, ttl time.Duration, maxBytes int64) *Cache {
+	return &Cache{Dir: dir, TTL: ttl, MaxBytes: maxBytes}
+}
+
+// Key returns the hash of the request.
+func Key(request any) (string, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key+entryExt)
+}
+
+// Get returns the entry of the key if it exists and has not expired.
+func (c *Cache) Get(key string) (*Entry, bool) {
+	if c == nil || c.Refresh {
+		return nil, false
+	}
+	entry, err := c.load(key)
+	if err != nil || c.expired(entry) {
+		c.count(false)
+		return nil, false
+	}
+	c.count(true)
+	return entry, true
+}
+
+func (c *Cache) Put(key string, entry *Entry) error {
+	if c == nil {
+		return nil
+	}
+	if c.Defer {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.pending == nil {
+			c.pending = map[string]*Entry{}
+		}
+		c.pending[key] = entry
+		return nil
+	}
+	return c.store(key, entry)
+}
+
+// Commit stores the entries kept by Defer.
+func (c *Cache) Commit() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for key, entry := range pending {
+		if err := c.store(key, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard drops the entries kept by Defer, such as the responses that failed the validation.
+func (c *Cache) Discard() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.pending = nil
+	c.mu.Unlock()
+}
+
+func (c *Cache) store(key string, entry *Entry) error {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return err
+	}
+	entry.CreatedAt = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it, so that readers never see a partial entry.
+	tmp, err := os.CreateTemp(c.Dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return c.prune()
+}
+
+func (c *Cache) load(key string) (*Entry, error) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (c *Cache) expired(entry *Entry) bool {
+	return c.TTL > 0 && time.Since(entry.CreatedAt) > c.TTL
+}
+
+type file struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *Cache) entries() ([]*file, error) {
+	dirEntries, err := os.ReadDir(c.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := []*file{}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != entryExt || dirEntry.Name() == statsFile {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, &file{
+			path:    filepath.Join(c.Dir, dirEntry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+// prune removes the expired entries, and the oldest entries beyond the size limit.
+func (c *Cache) prune() error {
+	files, err := c.entries()
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	var total int64
+	for _, f := range files {
+		expired := c.TTL > 0 && time.Since(f.modTime) > c.TTL
+		if expired || (c.MaxBytes > 0 && total+f.size > c.MaxBytes) {
+			if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		total += f.size
+	}
+	return nil
+}
+
+func (c *Cache) Stats() (*Stats, error) {
+	stats := c.loadStats()
+	files, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+	stats.Entries = len(files)
+	for _, f := range files {
+		stats.Bytes += f.size
+	}
+	return stats, nil
+}
+
+// Clear removes all entries and the statistics.
+func (c *Cache) Clear() error {
+	files, err := c.entries()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(c.Dir, statsFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (c *Cache) loadStats() *Stats {
+	stats := &Stats{}
+	data, err := os.ReadFile(filepath.Join(c.Dir, statsFile))
+	if err != nil {
+		return stats
+	}
+	json.Unmarshal(data, stats)
+	return stats
+}
+
+// count updates the hit and miss counts. They are approximate when processes run concurrently.
+func (c *Cache) count(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.loadStats()
+	if hit {
+		stats.Hits++
+	} else {
+		stats.Misses++
+	}
+	data, err := json.Marshal(&Stats{Hits: stats.Hits, Misses: stats.Misses})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return
+	}
+	os.WriteFile(filepath.Join(c.Dir, statsFile), data, 0o600)
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, cache *Cache) context.Context {
+	return context.WithValue(ctx, contextKey{}, cache)
+}
+
+// FromContext returns the cache in the context, or nil.
+func FromContext(ctx context.Context) *Cache {
+	cache, _ := ctx.Value(contextKey{}).(*Cache)
+	return cache
+}
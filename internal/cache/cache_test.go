@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+func TestCacheGetPut(t *testing.T) {
+	c := New(t.TempDir(), 0, 0)
+	key, err := Key(map[string]string{"model": "gpt-4o-mini"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(key); ok {
+		t.Fatalf("Get() hit before Put")
+	}
+	entry := &Entry{Chunks: []*payload.Message{
+		{Role: "assistant", Content: "Hello"},
+		{Content: ", world"},
+	}}
+	if err := c.Put(key, entry); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := c.Get(key)
+	if !ok {
+		t.Fatalf("Get() missed after Put")
+	}
+	if message := got.Message(); message.Role != "assistant" || message.Content != "Hello, world" {
+		t.Errorf("Message() = %+v", message)
+	}
+
+	c.Refresh = true
+	if _, ok := c.Get(key); ok {
+		t.Errorf("Get() hit with Refresh")
+	}
+
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 1 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (Stats{}) {
+		t.Errorf("Stats() after Clear = %+v", stats)
+	}
+}
+
+func TestCacheExpired(t *testing.T) {
+	c := New(t.TempDir(), time.Hour, 0)
+	if err := c.Put("key", &Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	path := filepath.Join(c.Dir, "key"+entryExt)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := c.load("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.CreatedAt = old
+	if !c.expired(entry) {
+		t.Errorf("expired() = false")
+	}
+	if err := c.prune(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("prune() kept the expired entry")
+	}
+}
+
+func TestCachePruneMaxBytes(t *testing.T) {
+	c := New(t.TempDir(), 0, 0)
+	now := time.Now()
+	for i, key := range []string{"old", "new"} {
+		if err := c.Put(key, &Entry{Chunks: []*payload.Message{{Content: "content"}}}); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i-2) * time.Minute)
+		if err := os.Chtimes(filepath.Join(c.Dir, key+entryExt), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(filepath.Join(c.Dir, "new"+entryExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MaxBytes = info.Size()
+	if err := c.prune(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(c.Dir, "old"+entryExt)); !os.IsNotExist(err) {
+		t.Errorf("prune() kept the oldest entry")
+	}
+	if _, err := os.Stat(filepath.Join(c.Dir, "new"+entryExt)); err != nil {
+		t.Errorf("prune() removed the newest entry: %v", err)
+	}
+}
+
+func TestCacheDefer(t *testing.T) {
+	c := New(t.TempDir(), 0, 0)
+	c.Defer = true
+	entry := &Entry{Chunks: []*payload.Message{{Role: "assistant", Content: "Hello"}}}
+
+	if err := c.Put("rejected", entry); err != nil {
+		t.Fatal(err)
+	}
+	c.Discard()
+	if err := c.Put("accepted", entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("accepted"); ok {
+		t.Fatalf("Get() hit before Commit")
+	}
+	if err := c.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("accepted"); !ok {
+		t.Errorf("Get() missed after Commit")
+	}
+	if _, ok := c.Get("rejected"); ok {
+		t.Errorf("Get() hit the discarded entry")
+	}
+}
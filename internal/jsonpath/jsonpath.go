@@ -0,0 +1,155 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a sequence of object keys and array indexes.
+// It accepts both a jq-like form (.a.b[0], .["a b"]) and a JSONPath-like form ($.a.b[0], $['a b']).
+type Path []*Step
+
+type Step struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+func (s *Step) String() string {
+	if s.IsIndex {
+		return fmt.Sprintf("[%d]", s.Index)
+	}
+	if isIdentifier(s.Key) {
+		return "." + s.Key
+	}
+	return fmt.Sprintf(".[%q]", s.Key)
+}
+
+type NotFoundError struct {
+	Path string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s: no such path", e.Path)
+}
+
+func Parse(expr string) (Path, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "$") {
+		expr = expr[1:]
+	} else if !strings.HasPrefix(expr, ".") && !strings.HasPrefix(expr, "[") {
+		return nil, fmt.Errorf("%q: path must start with \".\", \"[\" or \"$\"", expr)
+	}
+
+	path := Path{}
+	for i := 0; i < len(expr); {
+		switch expr[i] {
+		case '.':
+			i++
+			if i < len(expr) && expr[i] == '[' {
+				continue
+			}
+			start := i
+			for i < len(expr) && expr[i] != '.' && expr[i] != '[' {
+				i++
+			}
+			if key := expr[start:i]; key != "" {
+				path = append(path, &Step{Key: key})
+			} else if i < len(expr) {
+				return nil, fmt.Errorf("%q: empty key at %d", expr, start)
+			}
+		case '[':
+			end, step, err := parseBracket(expr, i)
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, step)
+			i = end
+		default:
+			return nil, fmt.Errorf("%q: unexpected character %q at %d", expr, expr[i], i)
+		}
+	}
+	return path, nil
+}
+
+func parseBracket(expr string, start int) (int, *Step, error) {
+	i := start + 1
+	if i < len(expr) && (expr[i] == '"' || expr[i] == '\'') {
+		quote := expr[i]
+		var key strings.Builder
+		for i++; i < len(expr) && expr[i] != quote; i++ {
+			if expr[i] == '\\' && i+1 < len(expr) {
+				i++
+			}
+			key.WriteByte(expr[i])
+		}
+		if i+1 >= len(expr) || expr[i+1] != ']' {
+			return 0, nil, fmt.Errorf("%q: unterminated bracket at %d", expr, start)
+		}
+		return i + 2, &Step{Key: key.String()}, nil
+	}
+
+	end := strings.IndexByte(expr[i:], ']')
+	if end < 0 {
+		return 0, nil, fmt.Errorf("%q: unterminated bracket at %d", expr, start)
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(expr[i : i+end]))
+	if err != nil {
+		return 0, nil, fmt.Errorf("%q: invalid index at %d", expr, start)
+	}
+	return i + end + 1, &Step{Index: index, IsIndex: true}, nil
+}
+
+// Lookup returns the value at the path in a value decoded by encoding/json.
+// Negative indexes count from the end of an array.
+func (p Path) Lookup(v any) (any, error) {
+	current := v
+	for i, step := range p {
+		found := false
+		if step.IsIndex {
+			if array, ok := current.([]any); ok {
+				index := step.Index
+				if index < 0 {
+					index += len(array)
+				}
+				if index >= 0 && index < len(array) {
+					current = array[index]
+					found = true
+				}
+			}
+		} else {
+			if object, ok := current.(map[string]any); ok {
+				current, found = object[step.Key]
+			}
+		}
+		if !found {
+			return nil, &NotFoundError{Path: p[:i+1].String()}
+		}
+	}
+	return current, nil
+}
+
+func (p Path) String() string {
+	if len(p) == 0 {
+		return "."
+	}
+	var buf strings.Builder
+	for _, step := range p {
+		buf.WriteString(step.String())
+	}
+	return buf.String()
+}
+
+func isIdentifier(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, r := range key {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || (i > 0 && '0' <= r && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
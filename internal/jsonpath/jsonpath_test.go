@@ -0,0 +1,62 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	var doc any
+	if err := json.Unmarshal([]byte(`{"a":{"b c":[1,"two",{"d":null}]},"e":"f"}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr  string
+		value any
+		found bool
+	}{
+		{expr: ".", value: doc, found: true},
+		{expr: ".e", value: "f", found: true},
+		{expr: "$.e", value: "f", found: true},
+		{expr: `.a["b c"][1]`, value: "two", found: true},
+		{expr: `$['a']['b c'][-1].d`, value: nil, found: true},
+		{expr: `.a.["b c"][0]`, value: float64(1), found: true},
+		{expr: ".x", found: false},
+		{expr: `.a["b c"][3]`, found: false},
+		{expr: ".e.f", found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			path, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse should not return error, but got %v", err)
+			}
+			value, err := path.Lookup(doc)
+			if !tt.found {
+				var nerr *NotFoundError
+				if !errors.As(err, &nerr) {
+					t.Errorf("Lookup should return NotFoundError, but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Lookup should not return error, but got %v", err)
+			}
+			if !reflect.DeepEqual(value, tt.value) {
+				t.Errorf("Lookup should return %v, but got %v", tt.value, value)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, expr := range []string{"a", ".a[", `.["a]`, ".a[x]", ".a..b"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) should return error", expr)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode"
+)
+
+// object keeps the order of members, so that generated schemas follow the order of their sources.
+type object []*member
+
+type member struct {
+	key   string
+	value any
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(m.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// FromExample generates a schema compatible with OpenAI's strict mode from an example JSON document.
+// The items of an array are inferred from its first element.
+func FromExample(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	schema, err := schemaFromExample(decoder, "#")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after top-level value")
+	}
+	return marshalRoot(schema)
+}
+
+func schemaFromExample(decoder *json.Decoder, pointer string) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		switch token {
+		case '{':
+			properties := object{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				name := key.(string)
+				property, err := schemaFromExample(decoder, pointer+"/"+escapePointer(name))
+				if err != nil {
+					return nil, err
+				}
+				properties = append(properties, &member{name, property})
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return strictObject(properties), nil
+		case '[':
+			var items any
+			for i := 0; decoder.More(); i++ {
+				item, err := schemaFromExample(decoder, fmt.Sprintf("%s/%d", pointer, i))
+				if err != nil {
+					return nil, err
+				}
+				if i == 0 {
+					items = item
+				}
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			if items == nil {
+				return nil, fmt.Errorf("%s: cannot infer the type of items from an empty array", pointer)
+			}
+			return object{{"type", "array"}, {"items", items}}, nil
+		}
+	case string:
+		return object{{"type", "string"}}, nil
+	case json.Number:
+		return object{{"type", "number"}}, nil
+	case bool:
+		return object{{"type", "boolean"}}, nil
+	case nil:
+		return object{{"type", "null"}}, nil
+	}
+	return nil, fmt.Errorf("%s: unexpected token %v", pointer, token)
+}
+
+// FromSpec generates a schema compatible with OpenAI's strict mode from Go-like type declarations
+// such as "command:string, confidence:number, files:[string], meta:{author:string?}".
+// Supported types are string, number, integer and boolean, [T] for arrays, {...} for objects,
+// and a trailing "?" for nullable types.
+func FromSpec(spec string) ([]byte, error) {
+	p := &specParser{src: spec}
+	p.skipSpaces()
+	braced := p.consume('{')
+	properties, err := p.parseFields()
+	if err != nil {
+		return nil, err
+	}
+	if braced && !p.consume('}') {
+		return nil, p.errorf("expected \"}\"")
+	}
+	p.skipSpaces()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected character %q", p.src[p.pos])
+	}
+	return marshalRoot(strictObject(properties))
+}
+
+type specParser struct {
+	src string
+	pos int
+}
+
+func (p *specParser) parseFields() (object, error) {
+	properties := object{}
+	seen := map[string]bool{}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.src) || p.src[p.pos] == '}' {
+			return properties, nil
+		}
+		name := p.parseName()
+		if name == "" {
+			return nil, p.errorf("expected field name")
+		}
+		if seen[name] {
+			return nil, p.errorf("duplicate field %q", name)
+		}
+		seen[name] = true
+		if !p.consume(':') {
+			return nil, p.errorf("expected \":\" after %q", name)
+		}
+		property, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		properties = append(properties, &member{name, property})
+		if !p.consume(',') {
+			p.skipSpaces()
+			return properties, nil
+		}
+	}
+}
+
+func (p *specParser) parseType() (any, error) {
+	p.skipSpaces()
+	var schema object
+	switch {
+	case p.consume('['):
+		items, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(']') {
+			return nil, p.errorf("expected \"]\"")
+		}
+		schema = object{{"type", "array"}, {"items", items}}
+	case p.consume('{'):
+		properties, err := p.parseFields()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume('}') {
+			return nil, p.errorf("expected \"}\"")
+		}
+		schema = strictObject(properties)
+	default:
+		name := p.parseName()
+		switch name {
+		case "string", "number", "integer", "boolean":
+			schema = object{{"type", name}}
+		case "":
+			return nil, p.errorf("expected type")
+		default:
+			return nil, p.errorf("unknown type %q", name)
+		}
+	}
+
+	if p.consume('?') {
+		schema[0].value = []string{schema[0].value.(string), "null"}
+	}
+	return schema, nil
+}
+
+func (p *specParser) parseName() string {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.src) {
+		r := rune(p.src[p.pos])
+		if r != '_' && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *specParser) consume(c byte) bool {
+	p.skipSpaces()
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *specParser) skipSpaces() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *specParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("spec:%d: %s", p.pos+1, fmt.Sprintf(format, a...))
+}
+
+func strictObject(properties object) object {
+	required := make([]string, len(properties))
+	for i, property := range properties {
+		required[i] = property.key
+	}
+	return object{
+		{"type", "object"},
+		{"properties", properties},
+		{"additionalProperties", false},
+		{"required", required},
+	}
+}
+
+func marshalRoot(schema any) ([]byte, error) {
+	if o, ok := schema.(object); !ok || o[0].value != "object" {
+		return nil, fmt.Errorf("root of the example must be an object")
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
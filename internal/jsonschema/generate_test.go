@@ -0,0 +1,91 @@
+package jsonschema
+
+import (
+	"testing"
+)
+
+func TestFromSpec(t *testing.T) {
+	schema, err := FromSpec("suggested_command:string, confidence:number, files:[string], meta:{author:string?, lines:integer}")
+	if err != nil {
+		t.Fatalf("FromSpec should not return error, but got %v", err)
+	}
+	expected := `{
+  "type": "object",
+  "properties": {
+    "suggested_command": {
+      "type": "string"
+    },
+    "confidence": {
+      "type": "number"
+    },
+    "files": {
+      "type": "array",
+      "items": {
+        "type": "string"
+      }
+    },
+    "meta": {
+      "type": "object",
+      "properties": {
+        "author": {
+          "type": [
+            "string",
+            "null"
+          ]
+        },
+        "lines": {
+          "type": "integer"
+        }
+      },
+      "additionalProperties": false,
+      "required": [
+        "author",
+        "lines"
+      ]
+    }
+  },
+  "additionalProperties": false,
+  "required": [
+    "suggested_command",
+    "confidence",
+    "files",
+    "meta"
+  ]
+}
+`
+	if string(schema) != expected {
+		t.Errorf("FromSpec should return\n%s\nbut got\n%s", expected, schema)
+	}
+}
+
+func TestFromSpecError(t *testing.T) {
+	for _, spec := range []string{"a", "a:", "a:str", "a:[string", "a:string, a:number", "a:string b:string"} {
+		if _, err := FromSpec(spec); err == nil {
+			t.Errorf("FromSpec(%q) should return error", spec)
+		}
+	}
+}
+
+func TestFromExample(t *testing.T) {
+	example := `{"command":"ls","confidence":0.9,"files":[{"name":"a.go","lines":[1,2]}],"draft":false,"note":null}`
+	data, err := FromExample([]byte(example))
+	if err != nil {
+		t.Fatalf("FromExample should not return error, but got %v", err)
+	}
+	schema, err := Compile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.ValidateJSON([]byte(example)); err != nil {
+		t.Errorf("Generated schema should accept the example, but got %v", err)
+	}
+	if err := schema.ValidateJSON([]byte(`{"command":"ls","confidence":0.9,"files":[{"name":"a.go"}],"draft":false,"note":null}`)); err == nil {
+		t.Errorf("Generated schema should require all properties of nested objects")
+	}
+
+	for _, example := range []string{`[]`, `{"a":[]}`, `{"a":`} {
+		if _, err := FromExample([]byte(example)); err == nil {
+			t.Errorf("FromExample(%q) should return error", example)
+		}
+	}
+}
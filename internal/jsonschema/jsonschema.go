@@ -0,0 +1,512 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON schema.
+// It supports the subset of draft 2020-12 used for structured outputs:
+// type, enum, const, properties, required, additionalProperties, patternProperties,
+// items, prefixItems, min/maxItems, uniqueItems, min/maxLength, pattern,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf,
+// min/maxProperties, allOf, anyOf, oneOf, not and local $ref.
+type Schema struct {
+	root    any
+	regexps map[string]*regexp.Regexp
+}
+
+type Error struct {
+	InstancePath string `json:"instance_path"`
+	Keyword      string `json:"keyword"`
+	Message      string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	path := e.InstancePath
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, e.Message)
+}
+
+type ValidationError struct {
+	Errors []*Error
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func Compile(data []byte) (*Schema, error) {
+	root, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	switch root.(type) {
+	case bool, map[string]any:
+	default:
+		return nil, fmt.Errorf("schema must be an object or a boolean")
+	}
+	return &Schema{
+		root:    root,
+		regexps: map[string]*regexp.Regexp{},
+	}, nil
+}
+
+func (s *Schema) ValidateJSON(data []byte) error {
+	instance, err := decode(data)
+	if err != nil {
+		return &ValidationError{Errors: []*Error{{Keyword: "json", Message: fmt.Sprintf("invalid JSON: %v", err)}}}
+	}
+	return s.Validate(instance)
+}
+
+// Validate validates a value decoded by encoding/json. Numbers may be float64 or json.Number.
+func (s *Schema) Validate(instance any) error {
+	errs := s.validate(s.root, instance, "", 0)
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+const maxRefDepth = 64
+
+func (s *Schema) validate(schema, instance any, path string, depth int) []*Error {
+	switch schema := schema.(type) {
+	case bool:
+		if !schema {
+			return []*Error{newError(path, "false", "no value is allowed")}
+		}
+		return nil
+	case map[string]any:
+		return s.validateObject(schema, instance, path, depth)
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(schema map[string]any, instance any, path string, depth int) []*Error {
+	errs := []*Error{}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if depth >= maxRefDepth {
+			return append(errs, newError(path, "$ref", "too deeply nested references"))
+		}
+		target, err := s.resolve(ref)
+		if err != nil {
+			return append(errs, newError(path, "$ref", err.Error()))
+		}
+		errs = append(errs, s.validate(target, instance, path, depth+1)...)
+	}
+
+	if t, ok := schema["type"]; ok {
+		types := []string{}
+		switch t := t.(type) {
+		case string:
+			types = append(types, t)
+		case []any:
+			for _, v := range t {
+				if v, ok := v.(string); ok {
+					types = append(types, v)
+				}
+			}
+		}
+		matched := false
+		for _, t := range types {
+			if isType(instance, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return append(errs, newError(path, "type", fmt.Sprintf("expected %s, but got %s", strings.Join(types, " or "), typeOf(instance))))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, v := range enum {
+			if equal(v, instance) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, newError(path, "enum", fmt.Sprintf("must be one of %s", encode(enum))))
+		}
+	}
+	if c, ok := schema["const"]; ok && !equal(c, instance) {
+		errs = append(errs, newError(path, "const", fmt.Sprintf("must be %s", encode(c))))
+	}
+
+	switch instance := instance.(type) {
+	case map[string]any:
+		errs = append(errs, s.validateProperties(schema, instance, path, depth)...)
+	case []any:
+		errs = append(errs, s.validateItems(schema, instance, path, depth)...)
+	case string:
+		errs = append(errs, s.validateString(schema, instance, path)...)
+	case json.Number:
+		if f, err := instance.Float64(); err == nil {
+			errs = append(errs, validateNumber(schema, f, path)...)
+		}
+	case float64:
+		errs = append(errs, validateNumber(schema, instance, path)...)
+	}
+
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, subschema := range allOf {
+			errs = append(errs, s.validate(subschema, instance, path, depth)...)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, subschema := range anyOf {
+			if len(s.validate(subschema, instance, path, depth)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, newError(path, "anyOf", "must match at least one of the schemas in \"anyOf\""))
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		count := 0
+		for _, subschema := range oneOf {
+			if len(s.validate(subschema, instance, path, depth)) == 0 {
+				count++
+			}
+		}
+		if count != 1 {
+			errs = append(errs, newError(path, "oneOf", fmt.Sprintf("must match exactly one of the schemas in \"oneOf\", but matched %d", count)))
+		}
+	}
+	if not, ok := schema["not"]; ok {
+		if len(s.validate(not, instance, path, depth)) == 0 {
+			errs = append(errs, newError(path, "not", "must not match the schema in \"not\""))
+		}
+	}
+
+	return errs
+}
+
+func (s *Schema) validateProperties(schema map[string]any, instance map[string]any, path string, depth int) []*Error {
+	errs := []*Error{}
+
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := instance[name]; !ok {
+					errs = append(errs, newError(path, "required", fmt.Sprintf("missing required property %q", name)))
+				}
+			}
+		}
+	}
+	if n, ok := toFloat(schema["minProperties"]); ok && float64(len(instance)) < n {
+		errs = append(errs, newError(path, "minProperties", fmt.Sprintf("must have at least %v properties", n)))
+	}
+	if n, ok := toFloat(schema["maxProperties"]); ok && float64(len(instance)) > n {
+		errs = append(errs, newError(path, "maxProperties", fmt.Sprintf("must have at most %v properties", n)))
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	patternProperties, _ := schema["patternProperties"].(map[string]any)
+	additional, hasAdditional := schema["additionalProperties"]
+
+	for _, name := range sortedKeys(instance) {
+		value := instance[name]
+		propertyPath := path + "/" + escapePointer(name)
+		evaluated := false
+		if property, ok := properties[name]; ok {
+			evaluated = true
+			errs = append(errs, s.validate(property, value, propertyPath, depth)...)
+		}
+		for _, pattern := range sortedKeys(patternProperties) {
+			re, err := s.regexp(pattern)
+			if err != nil {
+				errs = append(errs, newError(path, "patternProperties", err.Error()))
+				continue
+			}
+			if re.MatchString(name) {
+				evaluated = true
+				errs = append(errs, s.validate(patternProperties[pattern], value, propertyPath, depth)...)
+			}
+		}
+		if !evaluated && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				errs = append(errs, newError(path, "additionalProperties", fmt.Sprintf("property %q is not allowed", name)))
+				continue
+			}
+			errs = append(errs, s.validate(additional, value, propertyPath, depth)...)
+		}
+	}
+
+	return errs
+}
+
+func (s *Schema) validateItems(schema map[string]any, instance []any, path string, depth int) []*Error {
+	errs := []*Error{}
+
+	if n, ok := toFloat(schema["minItems"]); ok && float64(len(instance)) < n {
+		errs = append(errs, newError(path, "minItems", fmt.Sprintf("must have at least %v items", n)))
+	}
+	if n, ok := toFloat(schema["maxItems"]); ok && float64(len(instance)) > n {
+		errs = append(errs, newError(path, "maxItems", fmt.Sprintf("must have at most %v items", n)))
+	}
+	if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+	unique:
+		for i := range instance {
+			for j := i + 1; j < len(instance); j++ {
+				if equal(instance[i], instance[j]) {
+					errs = append(errs, newError(path, "uniqueItems", fmt.Sprintf("items at %d and %d are equal", i, j)))
+					break unique
+				}
+			}
+		}
+	}
+
+	prefixItems, _ := schema["prefixItems"].([]any)
+	for i, item := range instance {
+		itemPath := fmt.Sprintf("%s/%d", path, i)
+		if i < len(prefixItems) {
+			errs = append(errs, s.validate(prefixItems[i], item, itemPath, depth)...)
+			continue
+		}
+		if items, ok := schema["items"]; ok {
+			errs = append(errs, s.validate(items, item, itemPath, depth)...)
+		}
+	}
+
+	return errs
+}
+
+func (s *Schema) validateString(schema map[string]any, instance string, path string) []*Error {
+	errs := []*Error{}
+
+	length := float64(utf8.RuneCountInString(instance))
+	if n, ok := toFloat(schema["minLength"]); ok && length < n {
+		errs = append(errs, newError(path, "minLength", fmt.Sprintf("must be at least %v characters long", n)))
+	}
+	if n, ok := toFloat(schema["maxLength"]); ok && length > n {
+		errs = append(errs, newError(path, "maxLength", fmt.Sprintf("must be at most %v characters long", n)))
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := s.regexp(pattern)
+		if err != nil {
+			errs = append(errs, newError(path, "pattern", err.Error()))
+		} else if !re.MatchString(instance) {
+			errs = append(errs, newError(path, "pattern", fmt.Sprintf("must match the pattern %q", pattern)))
+		}
+	}
+
+	return errs
+}
+
+func validateNumber(schema map[string]any, instance float64, path string) []*Error {
+	errs := []*Error{}
+
+	if n, ok := toFloat(schema["minimum"]); ok && instance < n {
+		errs = append(errs, newError(path, "minimum", fmt.Sprintf("must be >= %v", n)))
+	}
+	if n, ok := toFloat(schema["maximum"]); ok && instance > n {
+		errs = append(errs, newError(path, "maximum", fmt.Sprintf("must be <= %v", n)))
+	}
+	if n, ok := toFloat(schema["exclusiveMinimum"]); ok && instance <= n {
+		errs = append(errs, newError(path, "exclusiveMinimum", fmt.Sprintf("must be > %v", n)))
+	}
+	if n, ok := toFloat(schema["exclusiveMaximum"]); ok && instance >= n {
+		errs = append(errs, newError(path, "exclusiveMaximum", fmt.Sprintf("must be < %v", n)))
+	}
+	if n, ok := toFloat(schema["multipleOf"]); ok && n > 0 {
+		if q := instance / n; math.Abs(q-math.Round(q)) > 1e-9 {
+			errs = append(errs, newError(path, "multipleOf", fmt.Sprintf("must be a multiple of %v", n)))
+		}
+	}
+
+	return errs
+}
+
+func (s *Schema) resolve(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported reference %q, only local references are supported", ref)
+	}
+	var current any = s.root
+	pointer := strings.TrimPrefix(ref, "#")
+	if pointer == "" {
+		return current, nil
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch c := current.(type) {
+		case map[string]any:
+			next, ok := c[token]
+			if !ok {
+				return nil, fmt.Errorf("unresolvable reference %q", ref)
+			}
+			current = next
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, fmt.Errorf("unresolvable reference %q", ref)
+			}
+			current = c[i]
+		default:
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+	}
+	return current, nil
+}
+
+func (s *Schema) regexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := s.regexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	s.regexps[pattern] = re
+	return re, nil
+}
+
+func decode(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after top-level value")
+	}
+	return v, nil
+}
+
+func isType(instance any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := instance.(map[string]any)
+		return ok
+	case "array":
+		_, ok := instance.([]any)
+		return ok
+	case "string":
+		_, ok := instance.(string)
+		return ok
+	case "boolean":
+		_, ok := instance.(bool)
+		return ok
+	case "null":
+		return instance == nil
+	case "number":
+		_, ok := toFloat(instance)
+		return ok
+	case "integer":
+		f, ok := toFloat(instance)
+		return ok && f == math.Trunc(f)
+	}
+	return false
+}
+
+func typeOf(instance any) string {
+	switch instance.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	case json.Number, float64:
+		if isType(instance, "integer") {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", instance)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func equal(a, b any) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, va := range a {
+			vb, ok := b[k]
+			if !ok || !equal(va, vb) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func encode(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func newError(path, keyword, message string) *Error {
+	return &Error{InstancePath: path, Keyword: keyword, Message: message}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
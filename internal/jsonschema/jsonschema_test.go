@@ -0,0 +1,77 @@
+package jsonschema
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateJSON(t *testing.T) {
+	schema := `{
+  "type": "object",
+  "properties": {
+    "command": {"type": "string", "minLength": 1},
+    "confidence": {"type": "number", "minimum": 0, "maximum": 1},
+    "files": {"type": "array", "items": {"$ref": "#/$defs/file"}},
+    "kind": {"enum": ["shell", "code"]}
+  },
+  "additionalProperties": false,
+  "required": ["command", "confidence", "files", "kind"],
+  "$defs": {
+    "file": {"type": "string", "pattern": "\\.go$"}
+  }
+}`
+	tests := []struct {
+		name     string
+		instance string
+		keywords []string
+	}{
+		{
+			name:     "valid",
+			instance: `{"command":"ls","confidence":0.5,"files":["main.go"],"kind":"shell"}`,
+		},
+		{
+			name:     "invalid JSON",
+			instance: `{"command":`,
+			keywords: []string{"json"},
+		},
+		{
+			name:     "missing and additional properties",
+			instance: `{"command":"ls","confidence":1,"files":[],"extra":true}`,
+			keywords: []string{"required", "additionalProperties"},
+		},
+		{
+			name:     "type, range, pattern and enum",
+			instance: `{"command":"","confidence":2,"files":["main.rs",1],"kind":"sql"}`,
+			keywords: []string{"minLength", "maximum", "pattern", "type", "enum"},
+		},
+	}
+
+	s, err := Compile([]byte(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.ValidateJSON([]byte(tt.instance))
+			if len(tt.keywords) == 0 {
+				if err != nil {
+					t.Errorf("ValidateJSON should not return error, but got %v", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("ValidateJSON should return ValidationError, but got %v", err)
+			}
+			got := map[string]bool{}
+			for _, e := range verr.Errors {
+				got[e.Keyword] = true
+			}
+			for _, keyword := range tt.keywords {
+				if !got[keyword] {
+					t.Errorf("ValidateJSON should report %q error, but got %v", keyword, verr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+const cassetteVersion = 1
+
+// ErrNoInteraction is returned when no recorded interaction matches the request.
+var ErrNoInteraction = errors.New("no recorded interaction matches the request")
+
+// Cassette is a sequence of recorded exchanges with the provider.
+type Cassette struct {
+	Version      int            `json:"version"`
+	Interactions []*Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request *payload.Request `json:"request"`
+	Stream  bool             `json:"stream"`
+	// Response is recorded for a non-streaming request, and Chunks for a streaming one.
+	Response *payload.Response `json:"response,omitempty"`
+	Chunks   []*Chunk          `json:"chunks,omitempty"`
+	Error    *InteractionError `json:"error,omitempty"`
+}
+
+// Chunk is a streamed response with the delay since the previous chunk, or since the request for the first one.
+type Chunk struct {
+	DelayMs  float64           `json:"delay_ms"`
+	Response *payload.Response `json:"response"`
+}
+
+type InteractionError struct {
+	Kind       ErrorKind `json:"kind,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Message    string    `json:"message"`
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("%s: invalid cassette. %v", path, err)
+	}
+	if cassette.Version != cassetteVersion {
+		return nil, fmt.Errorf("%s: unsupported cassette version %d", path, cassette.Version)
+	}
+	return &cassette, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// Recorder is a LLMClient that records the exchanges of the client to the cassette file.
+type Recorder struct {
+	client   LLMClient
+	path     string
+	mu       sync.Mutex
+	cassette *Cassette
+}
+
+func NewRecorder(client LLMClient, path string) *Recorder {
+	return &Recorder{
+		client:   client,
+		path:     path,
+		cassette: &Cassette{Version: cassetteVersion},
+	}
+}
+
+func (r *Recorder) ChatCompletion(request *payload.Request, ctx context.Context) (*payload.Response, error) {
+	interaction := &Interaction{Request: copyRequest(request)}
+	response, err := r.client.ChatCompletion(request, ctx)
+	interaction.Response = response
+	interaction.Error = newInteractionError(err)
+	if err := r.record(interaction); err != nil {
+		return nil, err
+	}
+	return response, err
+}
+
+func (r *Recorder) ChatCompletionStream(request *payload.Request, ctx context.Context, onData func(*payload.Response) error) error {
+	interaction := &Interaction{Request: copyRequest(request), Stream: true}
+	last := time.Now()
+	err := r.client.ChatCompletionStream(request, ctx, func(response *payload.Response) error {
+		now := time.Now()
+		interaction.Chunks = append(interaction.Chunks, &Chunk{
+			DelayMs:  float64(now.Sub(last).Microseconds()) / 1000,
+			Response: response,
+		})
+		last = now
+		return onData(response)
+	})
+	interaction.Error = newInteractionError(err)
+	if err := r.record(interaction); err != nil {
+		return err
+	}
+	return err
+}
+
+// record saves the whole cassette on each interaction, so that it is kept when the session is interrupted.
+func (r *Recorder) record(interaction *Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	if err := r.cassette.Save(r.path); err != nil {
+		return fmt.Errorf("Failed to record the cassette. %v", err)
+	}
+	return nil
+}
+
+// Replayer is a LLMClient that serves the recorded exchanges without the provider.
+// Each interaction is served once, in the recorded order among those with the same request.
+type Replayer struct {
+	// Speed scales the delays between chunks. Zero replays without delays.
+	Speed float64
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+func NewReplayer(cassette *Cassette, speed float64) *Replayer {
+	return &Replayer{
+		Speed:    speed,
+		cassette: cassette,
+		used:     make([]bool, len(cassette.Interactions)),
+	}
+}
+
+func (r *Replayer) ChatCompletion(request *payload.Request, ctx context.Context) (*payload.Response, error) {
+	interaction, err := r.match(request)
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Error != nil {
+		return nil, interaction.Error.err()
+	}
+	if !interaction.Stream {
+		return interaction.Response, nil
+	}
+	// Join the chunks for a streamed interaction.
+	responses := make([]*payload.Response, len(interaction.Chunks))
+	for i, chunk := range interaction.Chunks {
+		responses[i] = chunk.Response
+	}
+	return joinResponses(responses), nil
+}
+
+func (r *Replayer) ChatCompletionStream(request *payload.Request, ctx context.Context, onData func(*payload.Response) error) error {
+	interaction, err := r.match(request)
+	if err != nil {
+		return err
+	}
+	if !interaction.Stream {
+		if interaction.Error != nil {
+			return interaction.Error.err()
+		}
+		return onData(interaction.Response)
+	}
+	for _, chunk := range interaction.Chunks {
+		if err := r.wait(ctx, chunk.DelayMs); err != nil {
+			return err
+		}
+		if err := onData(chunk.Response); err != nil {
+			return err
+		}
+	}
+	if interaction.Error != nil {
+		return interaction.Error.err()
+	}
+	return nil
+}
+
+func (r *Replayer) match(request *payload.Request) (*Interaction, error) {
+	key, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] {
+			continue
+		}
+		recorded, err := json.Marshal(interaction.Request)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(key, recorded) {
+			r.used[i] = true
+			return interaction, nil
+		}
+	}
+	return nil, ErrNoInteraction
+}
+
+func (r *Replayer) wait(ctx context.Context, delayMs float64) error {
+	if r.Speed <= 0 || delayMs <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(time.Duration(delayMs / r.Speed * float64(time.Millisecond)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// copyRequest copies the request, since the messages of the history are appended after the exchange.
+func copyRequest(request *payload.Request) *payload.Request {
+	copied := *request
+	copied.Messages = make([]*payload.Message, len(request.Messages))
+	for i, message := range request.Messages {
+		m := *message
+		copied.Messages[i] = &m
+	}
+	return &copied
+}
+
+func newInteractionError(err error) *InteractionError {
+	if err == nil {
+		return nil
+	}
+	interactionErr := &InteractionError{Message: err.Error()}
+	var llmErr *Error
+	if errors.As(err, &llmErr) {
+		interactionErr.Kind = llmErr.Kind
+		interactionErr.StatusCode = llmErr.StatusCode
+	}
+	return interactionErr
+}
+
+func (e *InteractionError) err() error {
+	if e.Kind == "" {
+		return errors.New(e.Message)
+	}
+	return &Error{Kind: e.Kind, StatusCode: e.StatusCode, Message: e.Message}
+}
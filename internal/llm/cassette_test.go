@@ -0,0 +1,95 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+type fakeClient struct {
+	chunks []string
+	err    error
+}
+
+func (c *fakeClient) ChatCompletion(request *payload.Request, ctx context.Context) (*payload.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	content := ""
+	for _, chunk := range c.chunks {
+		content += chunk
+	}
+	return &payload.Response{Message: &payload.Message{Role: "assistant", Content: content}}, nil
+}
+
+func (c *fakeClient) ChatCompletionStream(request *payload.Request, ctx context.Context, onData func(*payload.Response) error) error {
+	for i, chunk := range c.chunks {
+		message := &payload.Message{Content: chunk}
+		if i == 0 {
+			message.Role = "assistant"
+		}
+		if err := onData(&payload.Response{Message: message}); err != nil {
+			return err
+		}
+	}
+	return c.err
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	request := &payload.Request{Model: "gpt-4o-mini", Messages: []*payload.Message{{Role: "user", Content: "hi"}}}
+
+	recorder := NewRecorder(&fakeClient{chunks: []string{"Hello", ", world"}}, path)
+	if _, err := recorder.ChatCompletion(request, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.ChatCompletionStream(request, context.Background(), func(*payload.Response) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// The recorded request must not change with the history.
+	request.Messages = append(request.Messages, &payload.Message{Role: "assistant", Content: "Hello, world"})
+	recorder.client = &fakeClient{err: &Error{Kind: KindRateLimit, StatusCode: 429, Message: "slow down"}}
+	if _, err := recorder.ChatCompletion(request, context.Background()); !errors.Is(err, ErrRateLimit) {
+		t.Fatalf("ChatCompletion() error = %v", err)
+	}
+
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 3 {
+		t.Fatalf("len(Interactions) = %d, want 3", len(cassette.Interactions))
+	}
+	replayer := NewReplayer(cassette, 0)
+	first := &payload.Request{Model: "gpt-4o-mini", Messages: []*payload.Message{{Role: "user", Content: "hi"}}}
+
+	response, err := replayer.ChatCompletion(first, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Message.Content != "Hello, world" {
+		t.Errorf("ChatCompletion() = %q", response.Message.Content)
+	}
+
+	chunks := []string{}
+	err = replayer.ChatCompletionStream(first, context.Background(), func(response *payload.Response) error {
+		chunks = append(chunks, response.Message.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || chunks[0] != "Hello" || chunks[1] != ", world" {
+		t.Errorf("ChatCompletionStream() chunks = %q", chunks)
+	}
+
+	if _, err := replayer.ChatCompletion(request, context.Background()); !errors.Is(err, ErrRateLimit) {
+		t.Errorf("ChatCompletion() error = %v, want rate_limit", err)
+	}
+	if _, err := replayer.ChatCompletion(first, context.Background()); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("ChatCompletion() error = %v, want ErrNoInteraction", err)
+	}
+}
@@ -14,5 +14,5 @@ type LLMClient interface {
 
 func GetLLMClient(model string) LLMClient {
 	// First, only OpenAI is supported
-	return openai.NewClient()
+	return &errorClient{client: openai.NewClient()}
 }
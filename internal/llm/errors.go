@@ -0,0 +1,136 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/monochromegane/afa/internal/llm/openai"
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+type ErrorKind string
+
+const (
+	KindAuth           ErrorKind = "auth"
+	KindRateLimit      ErrorKind = "rate_limit"
+	KindContextLength  ErrorKind = "context_length"
+	KindRefusal        ErrorKind = "refusal"
+	KindNetwork        ErrorKind = "network"
+	KindServer         ErrorKind = "server"
+	KindInvalidRequest ErrorKind = "invalid_request"
+)
+
+// Error is an error of the LLM provider classified by its kind.
+// Use errors.Is with the sentinel errors to check the kind. (e.g. errors.Is(err, llm.ErrRateLimit))
+type Error struct {
+	Kind       ErrorKind
+	StatusCode int
+	Message    string
+	Err        error
+}
+
+var (
+	ErrAuth           = &Error{Kind: KindAuth}
+	ErrRateLimit      = &Error{Kind: KindRateLimit}
+	ErrContextLength  = &Error{Kind: KindContextLength}
+	ErrRefusal        = &Error{Kind: KindRefusal}
+	ErrNetwork        = &Error{Kind: KindNetwork}
+	ErrServer         = &Error{Kind: KindServer}
+	ErrInvalidRequest = &Error{Kind: KindInvalidRequest}
+)
+
+func (e *Error) Error() string {
+	if e.Message == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Kind == e.Kind && t.StatusCode == 0 && t.Message == "" && t.Err == nil
+}
+
+// classify converts an error of the provider client to Error.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var llmErr *Error
+	if errors.As(err, &llmErr) {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return &Error{
+			Kind:       kindFromStatus(apiErr.StatusCode, apiErr.Code),
+			StatusCode: apiErr.StatusCode,
+			Message:    apiErr.Error(),
+			Err:        err,
+		}
+	}
+	var refusalErr *openai.RefusalError
+	if errors.As(err, &refusalErr) {
+		return &Error{Kind: KindRefusal, Message: refusalErr.Error(), Err: err}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return &Error{Kind: KindNetwork, Message: fmt.Sprintf("Failed to connect to the provider. %v", err), Err: err}
+	}
+	return err
+}
+
+func kindFromStatus(status int, code string) ErrorKind {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return KindAuth
+	case status == http.StatusTooManyRequests:
+		return KindRateLimit
+	case code == "context_length_exceeded":
+		return KindContextLength
+	case status >= http.StatusInternalServerError:
+		return KindServer
+	default:
+		return KindInvalidRequest
+	}
+}
+
+// errorClient classifies errors of the provider client.
+type errorClient struct {
+	client LLMClient
+}
+
+func (c *errorClient) ChatCompletion(request *payload.Request, ctx context.Context) (*payload.Response, error) {
+	response, err := c.client.ChatCompletion(request, ctx)
+	return response, classify(err)
+}
+
+func (c *errorClient) ChatCompletionStream(request *payload.Request, ctx context.Context, onData func(*payload.Response) error) error {
+	// Errors of the callback are returned as they are.
+	var callbackErr error
+	err := c.client.ChatCompletionStream(request, ctx, func(response *payload.Response) error {
+		if err := onData(response); err != nil {
+			callbackErr = err
+			return err
+		}
+		return nil
+	})
+	if callbackErr != nil {
+		return callbackErr
+	}
+	return classify(err)
+}
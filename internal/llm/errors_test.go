@@ -0,0 +1,39 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/monochromegane/afa/internal/llm/openai"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{&openai.APIError{StatusCode: 401}, ErrAuth},
+		{&openai.APIError{StatusCode: 429}, ErrRateLimit},
+		{&openai.APIError{StatusCode: 400, Code: "context_length_exceeded"}, ErrContextLength},
+		{&openai.APIError{StatusCode: 400}, ErrInvalidRequest},
+		{&openai.APIError{StatusCode: 503}, ErrServer},
+		{&openai.RefusalError{Refusal: "no"}, ErrRefusal},
+		{fmt.Errorf("post: %w", &net.OpError{Op: "dial", Err: errors.New("refused")}), ErrNetwork},
+	}
+	for _, tt := range tests {
+		got := classify(tt.err)
+		if !errors.Is(got, tt.want) {
+			t.Errorf("classify(%v) = %v, want kind %s", tt.err, got, tt.want.(*Error).Kind)
+		}
+		if !errors.Is(got, tt.err) {
+			t.Errorf("classify(%v) should wrap the original error", tt.err)
+		}
+	}
+
+	if err := classify(context.Canceled); err != context.Canceled {
+		t.Errorf("classify should keep context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Package llmtest provides a fake OpenAI server for tests.
+package llmtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/monochromegane/afa/internal/llm/openai"
+)
+
+// Response is a scripted response of the server.
+type Response struct {
+	// Role of the message. (default "assistant")
+	Role    string
+	Content string
+	// Chunks are the deltas of a streamed response. Content is sent as one chunk if it is empty.
+	Chunks  []string
+	Refusal string
+	Usage   *openai.Usage
+
+	// StatusCode responds with an error of the API. (e.g. {"error":{"message":"...","type":"...","code":"..."}})
+	StatusCode   int
+	ErrorType    string
+	ErrorCode    string
+	ErrorMessage string
+
+	// Delay is waited before the response, and before each chunk of a streamed response.
+	Delay time.Duration
+}
+
+// Server is an in-process server of the chat completions API.
+// Responses are served from Script if it is set, then from the queue, and then Default.
+type Server struct {
+	*httptest.Server
+
+	// APIKey requires the bearer token if it is not empty.
+	APIKey  string
+	Script  func(*openai.Request) *Response
+	Default *Response
+
+	mu       sync.Mutex
+	queue    []*Response
+	requests []*openai.Request
+}
+
+// NewServer starts the server that serves the responses in order.
+func NewServer(responses ...*Response) *Server {
+	s := &Server{queue: responses}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+openai.API_CHAT_COMPLETIONS_PATH, s.chatCompletions)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Client returns the client of the server.
+func (s *Server) Client() *openai.Client {
+	return &openai.Client{Endpoint: s.URL}
+}
+
+func (s *Server) Enqueue(responses ...*Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, responses...)
+}
+
+// Requests returns the received requests.
+func (s *Server) Requests() []*openai.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*openai.Request{}, s.requests...)
+}
+
+func (s *Server) next(request *openai.Request) *Response {
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	s.mu.Unlock()
+	// A script returns nil to fall back to the queue.
+	if s.Script != nil {
+		if response := s.Script(request); response != nil {
+			return response
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) > 0 {
+		response := s.queue[0]
+		s.queue = s.queue[1:]
+		return response
+	}
+	if s.Default != nil {
+		return s.Default
+	}
+	return &Response{
+		StatusCode:   http.StatusInternalServerError,
+		ErrorType:    "server_error",
+		ErrorMessage: "llmtest: no response is scripted",
+	}
+}
+
+func (s *Server) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	if s.APIKey != "" && r.Header.Get("Authorization") != "Bearer "+s.APIKey {
+		writeError(w, &Response{
+			StatusCode:   http.StatusUnauthorized,
+			ErrorType:    "invalid_request_error",
+			ErrorCode:    "invalid_api_key",
+			ErrorMessage: "Incorrect API key provided.",
+		})
+		return
+	}
+	var request openai.Request
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, &Response{
+			StatusCode:   http.StatusBadRequest,
+			ErrorType:    "invalid_request_error",
+			ErrorMessage: fmt.Sprintf("We could not parse the JSON body of your request. %v", err),
+		})
+		return
+	}
+
+	response := s.next(&request)
+	if !wait(r, response.Delay) {
+		return
+	}
+	if response.StatusCode != 0 && response.StatusCode != http.StatusOK {
+		writeError(w, response)
+		return
+	}
+	if request.Stream {
+		s.stream(w, r, &request, response)
+		return
+	}
+
+	message := &openai.Message{Role: response.role(), Content: response.Content, Refusal: response.Refusal}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&openai.Response{
+		Choices: []*openai.Choice{{Message: message}},
+		Usage:   response.Usage,
+	})
+}
+
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, request *openai.Request, response *Response) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, &Response{StatusCode: http.StatusInternalServerError, ErrorMessage: "llmtest: streaming is not supported"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(data any) {
+		encoded, _ := json.Marshal(data)
+		fmt.Fprintf(w, "data: %s\n\n", encoded)
+		flusher.Flush()
+	}
+	chunks := response.Chunks
+	if len(chunks) == 0 {
+		chunks = []string{response.Content}
+	}
+	for i, chunk := range chunks {
+		if i > 0 && !wait(r, response.Delay) {
+			return
+		}
+		delta := openai.Message{Content: chunk}
+		if i == 0 {
+			delta.Role = response.role()
+		}
+		send(&openai.ResponseStream{Choices: []*openai.ChoiceStream{{Delta: delta}}})
+	}
+	if response.Refusal != "" {
+		send(&openai.ResponseStream{Choices: []*openai.ChoiceStream{{Delta: openai.Message{Refusal: response.Refusal}}}})
+	}
+	if request.StreamOptions != nil && request.StreamOptions.IncludeUsage && response.Usage != nil {
+		send(&openai.ResponseStream{Choices: []*openai.ChoiceStream{}, Usage: response.Usage})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+func (r *Response) role() string {
+	if r.Role == "" {
+		return "assistant"
+	}
+	return r.Role
+}
+
+func writeError(w http.ResponseWriter, response *Response) {
+	var code any
+	if response.ErrorCode != "" {
+		code = response.ErrorCode
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.StatusCode)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": response.ErrorMessage,
+			"type":    response.ErrorType,
+			"code":    code,
+		},
+	})
+}
+
+// wait returns false if the client has gone.
+func wait(r *http.Request, delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-r.Context().Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -9,8 +9,11 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 
+	"github.com/monochromegane/afa/internal/cache"
 	"github.com/monochromegane/afa/internal/payload"
+	"github.com/monochromegane/afa/internal/trace"
 )
 
 const (
@@ -33,9 +36,24 @@ func NewClient() *Client {
 	}
 }
 
-func (c *Client) ChatCompletion(request *payload.Request, ctx context.Context) (*payload.Response, error) {
+func (c *Client) ChatCompletion(request *payload.Request, ctx context.Context) (_ *payload.Response, err error) {
+	exchange := trace.FromContext(ctx).Start()
+	defer func() {
+		exchange.Error(err)
+		exchange.Done()
+	}()
+
 	repacked := c.repackRequest(request)
-	req, err := c.newJsonRequest(ctx, repacked)
+	responseCache := cache.FromContext(ctx)
+	key, entry, err := c.lookupCache(responseCache, repacked, exchange)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		return &payload.Response{Message: entry.Message(), Usage: entry.Usage}, nil
+	}
+
+	req, err := c.newJsonRequest(ctx, repacked, exchange)
 	if err != nil {
 		return nil, err
 	}
@@ -46,28 +64,63 @@ func (c *Client) ChatCompletion(request *payload.Request, ctx context.Context) (
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	exchange.Response(resp, body)
+	if err != nil {
+		return nil, err
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Error: Status Code %d.\n", resp.StatusCode)
+		return nil, newAPIError(resp.StatusCode, body)
 	}
 
 	var output Response
-	if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+	if err := json.Unmarshal(body, &output); err != nil {
 		return nil, err
 	}
 
 	if len(output.Choices) > 0 {
 		if refusal := output.Choices[0].Message.Refusal; refusal != "" {
-			return nil, fmt.Errorf("Refused to respond %s.", refusal)
+			return nil, &RefusalError{Refusal: refusal}
 		}
 	}
 
-	return c.repackResponse(&output), nil
+	response := c.repackResponse(&output)
+	c.storeCache(responseCache, key, &cache.Entry{
+		Chunks: []*payload.Message{response.Message},
+		Usage:  response.Usage,
+	}, exchange)
+	return response, nil
 }
 
-func (c *Client) ChatCompletionStream(request *payload.Request, ctx context.Context, onData func(*payload.Response) error) error {
+func (c *Client) ChatCompletionStream(request *payload.Request, ctx context.Context, onData func(*payload.Response) error) (err error) {
+	exchange := trace.FromContext(ctx).Start()
+	defer func() {
+		exchange.Error(err)
+		exchange.Done()
+	}()
+
 	repacked := c.repackRequest(request)
+	responseCache := cache.FromContext(ctx)
+	key, entry, err := c.lookupCache(responseCache, repacked, exchange)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		for _, chunk := range entry.Chunks {
+			if err := onData(&payload.Response{Message: chunk}); err != nil {
+				return err
+			}
+		}
+		if entry.Usage != nil {
+			return onData(&payload.Response{Message: &payload.Message{}, Usage: entry.Usage})
+		}
+		return nil
+	}
+
 	repacked.Stream = true
-	req, err := c.newJsonRequest(ctx, repacked)
+	repacked.StreamOptions = &StreamOptions{IncludeUsage: true}
+	req, err := c.newJsonRequest(ctx, repacked, exchange)
 	if err != nil {
 		return err
 	}
@@ -80,12 +133,15 @@ func (c *Client) ChatCompletionStream(request *payload.Request, ctx context.Cont
 
 	if resp.StatusCode != http.StatusOK {
 		body, err := io.ReadAll(resp.Body)
+		exchange.Response(resp, body)
 		if err != nil {
-			return fmt.Errorf("Error: Status Code %d.\n", resp.StatusCode)
+			return err
 		}
-		return fmt.Errorf("Error: Status Code %d, Response Body: %s.\n", resp.StatusCode, string(body))
+		return newAPIError(resp.StatusCode, body)
 	}
+	exchange.Response(resp, nil)
 
+	stored := &cache.Entry{}
 	reader := bufio.NewReader(resp.Body)
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -94,6 +150,9 @@ func (c *Client) ChatCompletionStream(request *payload.Request, ctx context.Cont
 		}
 
 		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			exchange.Event(line)
+		}
 		if !bytes.HasPrefix(line, dataPrefix) {
 			continue
 		}
@@ -110,19 +169,110 @@ func (c *Client) ChatCompletionStream(request *payload.Request, ctx context.Cont
 
 		if len(output.Choices) > 0 {
 			if refusal := output.Choices[0].Delta.Refusal; refusal != "" {
-				return fmt.Errorf("Refused to respond %s.", refusal)
+				return &RefusalError{Refusal: refusal}
 			}
 		}
 
-		if err := onData(c.repackResponseStream(&output)); err != nil {
+		response := c.repackResponseStream(&output)
+		if response.Message.Role != "" || response.Message.Content != "" {
+			stored.Chunks = append(stored.Chunks, response.Message)
+		}
+		if response.Usage != nil {
+			stored.Usage = response.Usage
+		}
+		if err := onData(response); err != nil {
 			return err
 		}
 	}
 
+	c.storeCache(responseCache, key, stored, exchange)
 	return nil
 }
 
-func (c *Client) newJsonRequest(ctx context.Context, request *Request) (*http.Request, error) {
+// cacheKey hashes the request as a non-streaming one with the endpoint, so that both modes share entries.
+func (c *Client) cacheKey(request *Request) (string, error) {
+	keyed := *request
+	keyed.Stream = false
+	keyed.StreamOptions = nil
+	return cache.Key(struct {
+		Endpoint string   `json:"endpoint"`
+		Request  *Request `json:"request"`
+	}{c.Endpoint, &keyed})
+}
+
+func (c *Client) lookupCache(responseCache *cache.Cache, request *Request, exchange *trace.Exchange) (string, *cache.Entry, error) {
+	if responseCache == nil {
+		return "", nil, nil
+	}
+	key, err := c.cacheKey(request)
+	if err != nil {
+		return "", nil, err
+	}
+	entry, ok := responseCache.Get(key)
+	if !ok {
+		exchange.Cache("miss", key)
+		return key, nil, nil
+	}
+	exchange.Cache("hit", key)
+	return key, entry, nil
+}
+
+// storeCache does not fail the response, since the cache is only an optimization.
+func (c *Client) storeCache(responseCache *cache.Cache, key string, entry *cache.Entry, exchange *trace.Exchange) {
+	if responseCache == nil {
+		return
+	}
+	if err := responseCache.Put(key, entry); err != nil {
+		exchange.Error(err)
+		return
+	}
+	exchange.Cache("store", key)
+}
+
+// APIError is an error response of the API. (e.g. {"error":{"message":"...","type":"...","code":"..."}})
+type APIError struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+}
+
+func newAPIError(statusCode int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode}
+	var output struct {
+		Error *struct {
+			Message string          `json:"message"`
+			Type    string          `json:"type"`
+			Code    json.RawMessage `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &output); err != nil || output.Error == nil {
+		apiErr.Message = strings.TrimSpace(string(body))
+		return apiErr
+	}
+	apiErr.Message = output.Error.Message
+	apiErr.Type = output.Error.Type
+	// The code is a string or null.
+	json.Unmarshal(output.Error.Code, &apiErr.Code)
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Error: Status Code %d.", e.StatusCode)
+	}
+	return fmt.Sprintf("Error: Status Code %d, %s", e.StatusCode, e.Message)
+}
+
+type RefusalError struct {
+	Refusal string
+}
+
+func (e *RefusalError) Error() string {
+	return fmt.Sprintf("Refused to respond %s.", e.Refusal)
+}
+
+func (c *Client) newJsonRequest(ctx context.Context, request *Request, exchange *trace.Exchange) (*http.Request, error) {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(&request); err != nil {
 		return nil, err
@@ -142,6 +292,7 @@ func (c *Client) newJsonRequest(ctx context.Context, request *Request) (*http.Re
 	if apiKey, ok := ctx.Value("openai-api-key").(string); ok {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
 	}
+	exchange.Request(req, buf.Bytes())
 
 	return req, nil
 }
@@ -177,6 +328,17 @@ func (c *Client) repackRequest(request *payload.Request) *Request {
 	return repacked
 }
 
+func (c *Client) repackUsage(usage *Usage) *payload.Usage {
+	if usage == nil {
+		return nil
+	}
+	return &payload.Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
+
 func (c *Client) repackResponse(response *Response) *payload.Response {
 	var message payload.Message
 	if len(response.Choices) > 0 {
@@ -185,6 +347,7 @@ func (c *Client) repackResponse(response *Response) *payload.Response {
 	}
 	return &payload.Response{
 		Message: &message,
+		Usage:   c.repackUsage(response.Usage),
 	}
 }
 
@@ -196,6 +359,7 @@ func (c *Client) repackResponseStream(response *ResponseStream) *payload.Respons
 	}
 	return &payload.Response{
 		Message: &message,
+		Usage:   c.repackUsage(response.Usage),
 	}
 }
 
@@ -203,9 +367,14 @@ type Request struct {
 	Model          string          `json:"model"`
 	Messages       []*Message      `json:"messages"`
 	Stream         bool            `json:"stream"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
 	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
 }
 
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
@@ -214,6 +383,7 @@ type Message struct {
 
 type Response struct {
 	Choices []*Choice `json:"choices"`
+	Usage   *Usage    `json:"usage,omitempty"`
 }
 
 type Choice struct {
@@ -222,12 +392,19 @@ type Choice struct {
 
 type ResponseStream struct {
 	Choices []*ChoiceStream `json:"choices"`
+	Usage   *Usage          `json:"usage,omitempty"`
 }
 
 type ChoiceStream struct {
 	Delta Message `json:"delta"`
 }
 
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
 type ResponseFormat struct {
 	Type       string      `json:"type"`
 	JsonSchema *JsonSchema `json:"json_schema"`
@@ -0,0 +1,189 @@
+package openai_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monochromegane/afa/internal/cache"
+	"github.com/monochromegane/afa/internal/llm/llmtest"
+	"github.com/monochromegane/afa/internal/llm/openai"
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+func newRequest() *payload.Request {
+	return &payload.Request{
+		Model:    "gpt-4o-mini",
+		Messages: []*payload.Message{{Role: "user", Content: "hi"}},
+	}
+}
+
+func TestChatCompletionStream(t *testing.T) {
+	usage := &openai.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}
+	tests := []struct {
+		name     string
+		response *llmtest.Response
+		timeout  time.Duration
+		chunks   []string
+		usage    *payload.Usage
+		err      func(error) bool
+	}{
+		{
+			name:     "chunks",
+			response: &llmtest.Response{Chunks: []string{"Hello", ", ", "world"}},
+			chunks:   []string{"Hello", ", ", "world"},
+		},
+		{
+			name:     "usage",
+			response: &llmtest.Response{Content: "Hello", Usage: usage},
+			chunks:   []string{"Hello", ""},
+			usage:    &payload.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+		},
+		{
+			name:     "refusal",
+			response: &llmtest.Response{Chunks: []string{"I"}, Refusal: "I can't help with that."},
+			chunks:   []string{"I"},
+			err: func(err error) bool {
+				var refusalErr *openai.RefusalError
+				return errors.As(err, &refusalErr) && refusalErr.Refusal == "I can't help with that."
+			},
+		},
+		{
+			name: "rate limit",
+			response: &llmtest.Response{
+				StatusCode:   http.StatusTooManyRequests,
+				ErrorType:    "requests",
+				ErrorCode:    "rate_limit_exceeded",
+				ErrorMessage: "Rate limit reached.",
+			},
+			err: func(err error) bool {
+				var apiErr *openai.APIError
+				return errors.As(err, &apiErr) && apiErr.StatusCode == 429 && apiErr.Code == "rate_limit_exceeded" && apiErr.Message == "Rate limit reached."
+			},
+		},
+		{
+			name:     "server error",
+			response: &llmtest.Response{StatusCode: http.StatusInternalServerError, ErrorMessage: "The server had an error."},
+			err: func(err error) bool {
+				var apiErr *openai.APIError
+				return errors.As(err, &apiErr) && apiErr.StatusCode == 500 && apiErr.Code == ""
+			},
+		},
+		{
+			name:     "slow stream",
+			response: &llmtest.Response{Chunks: []string{"Hello", "world"}, Delay: 50 * time.Millisecond},
+			timeout:  80 * time.Millisecond,
+			chunks:   []string{"Hello"},
+			err:      func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := llmtest.NewServer(tt.response)
+			defer server.Close()
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			chunks := []string{}
+			var usage *payload.Usage
+			err := server.Client().ChatCompletionStream(newRequest(), ctx, func(response *payload.Response) error {
+				chunks = append(chunks, response.Message.Content)
+				if response.Usage != nil {
+					usage = response.Usage
+				}
+				return nil
+			})
+			if tt.err == nil && err != nil {
+				t.Fatalf("ChatCompletionStream() error = %v", err)
+			}
+			if tt.err != nil && !tt.err(err) {
+				t.Fatalf("ChatCompletionStream() error = %v (%T)", err, err)
+			}
+			if strings.Join(chunks, "|") != strings.Join(tt.chunks, "|") {
+				t.Errorf("chunks = %q, want %q", chunks, tt.chunks)
+			}
+			if (usage == nil) != (tt.usage == nil) || (usage != nil && *usage != *tt.usage) {
+				t.Errorf("usage = %+v, want %+v", usage, tt.usage)
+			}
+
+			requests := server.Requests()
+			if len(requests) != 1 || !requests[0].Stream || requests[0].StreamOptions == nil || !requests[0].StreamOptions.IncludeUsage {
+				t.Errorf("request should be a stream with usage, but got %+v", requests)
+			}
+		})
+	}
+}
+
+func TestChatCompletion(t *testing.T) {
+	server := llmtest.NewServer(
+		&llmtest.Response{Content: "Hello", Usage: &openai.Usage{TotalTokens: 5}},
+		&llmtest.Response{Refusal: "No."},
+	)
+	server.APIKey = "test-key"
+	defer server.Close()
+	ctx := context.WithValue(context.Background(), "openai-api-key", "test-key")
+
+	response, err := server.Client().ChatCompletion(newRequest(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Message.Role != "assistant" || response.Message.Content != "Hello" || response.Usage.TotalTokens != 5 {
+		t.Errorf("ChatCompletion() = %+v, %+v", response.Message, response.Usage)
+	}
+
+	var refusalErr *openai.RefusalError
+	if _, err := server.Client().ChatCompletion(newRequest(), ctx); !errors.As(err, &refusalErr) {
+		t.Errorf("ChatCompletion() error = %v, want RefusalError", err)
+	}
+
+	var apiErr *openai.APIError
+	if _, err := server.Client().ChatCompletion(newRequest(), context.Background()); !errors.As(err, &apiErr) || apiErr.StatusCode != 401 {
+		t.Errorf("ChatCompletion() without the API key error = %v, want 401", err)
+	}
+}
+
+func TestChatCompletionCacheHitKeepsUsage(t *testing.T) {
+	server := llmtest.NewServer(&llmtest.Response{Content: "Hello", Usage: &openai.Usage{TotalTokens: 5}})
+	server.APIKey = "test-key"
+	defer server.Close()
+	ctx := context.WithValue(context.Background(), "openai-api-key", "test-key")
+	ctx = cache.NewContext(ctx, cache.New(t.TempDir(), 0, 0))
+
+	if _, err := server.Client().ChatCompletion(newRequest(), ctx); err != nil {
+		t.Fatal(err)
+	}
+	response, err := server.Client().ChatCompletion(newRequest(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Message.Content != "Hello" || response.Usage == nil || response.Usage.TotalTokens != 5 {
+		t.Errorf("ChatCompletion() from the cache = %+v, %+v", response.Message, response.Usage)
+	}
+
+	var content strings.Builder
+	var usage *payload.Usage
+	err = server.Client().ChatCompletionStream(newRequest(), ctx, func(response *payload.Response) error {
+		content.WriteString(response.Message.Content)
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.String() != "Hello" || usage == nil || usage.TotalTokens != 5 {
+		t.Errorf("ChatCompletionStream() from the cache = %q, %+v", content.String(), usage)
+	}
+	if len(server.Requests()) != 1 {
+		t.Errorf("requested %d times, want 1", len(server.Requests()))
+	}
+}
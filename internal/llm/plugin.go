@@ -0,0 +1,158 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+const PluginProtocolVersion = 1
+
+// PluginRequest is written to the standard input of a provider plugin as a JSON line.
+type PluginRequest struct {
+	Version int              `json:"version"`
+	Stream  bool             `json:"stream"`
+	Request *payload.Request `json:"request"`
+}
+
+// PluginMessage is read from the standard output of a provider plugin as a JSON line.
+type PluginMessage struct {
+	Response *payload.Response `json:"response,omitempty"`
+	Error    *PluginError      `json:"error,omitempty"`
+}
+
+type PluginError struct {
+	Type       ErrorKind `json:"type,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Message    string    `json:"message"`
+}
+
+// PluginClient runs the executable of a provider plugin for each request.
+type PluginClient struct {
+	Path string
+}
+
+func NewPluginClient(path string) *PluginClient {
+	return &PluginClient{Path: path}
+}
+
+func (c *PluginClient) ChatCompletion(request *payload.Request, ctx context.Context) (*payload.Response, error) {
+	responses := []*payload.Response{}
+	err := c.run(request, false, ctx, func(response *payload.Response) error {
+		responses = append(responses, response)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return joinResponses(responses), nil
+}
+
+func (c *PluginClient) ChatCompletionStream(request *payload.Request, ctx context.Context, onData func(*payload.Response) error) error {
+	return c.run(request, true, ctx, onData)
+}
+
+func (c *PluginClient) run(request *payload.Request, stream bool, ctx context.Context, onData func(*payload.Response) error) error {
+	input, err := json.Marshal(&PluginRequest{Version: PluginProtocolVersion, Stream: stream, Request: request})
+	if err != nil {
+		return err
+	}
+	// The plugin is killed when the callback fails.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(runCtx, c.Path)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start the provider plugin %s. %v", c.name(), err)
+	}
+
+	var pluginErr, readErr error
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 && readErr == nil && pluginErr == nil {
+			var message PluginMessage
+			if err := json.Unmarshal(line, &message); err != nil {
+				readErr = fmt.Errorf("Provider plugin %s wrote an invalid message. %v", c.name(), err)
+			} else if message.Error != nil {
+				pluginErr = message.Error.err()
+			} else if message.Response != nil {
+				// A chunk may carry only the usage, but the callbacks expect a message.
+				if message.Response.Message == nil {
+					message.Response.Message = &payload.Message{}
+				}
+				readErr = onData(message.Response)
+			}
+			if readErr != nil {
+				cancel()
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && readErr == nil {
+				readErr = err
+			}
+			break
+		}
+	}
+	waitErr := cmd.Wait()
+
+	switch {
+	case readErr != nil:
+		return readErr
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case pluginErr != nil:
+		return pluginErr
+	case waitErr != nil:
+		return fmt.Errorf("Provider plugin %s failed. %v %s", c.name(), waitErr, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (c *PluginClient) name() string {
+	return filepath.Base(c.Path)
+}
+
+func (e *PluginError) err() error {
+	if e.Type == "" {
+		return errors.New(e.Message)
+	}
+	return &Error{Kind: e.Type, StatusCode: e.StatusCode, Message: e.Message}
+}
+
+// joinResponses joins streamed responses into one.
+func joinResponses(responses []*payload.Response) *payload.Response {
+	message := &payload.Message{}
+	var content strings.Builder
+	var usage *payload.Usage
+	for _, response := range responses {
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+		if response.Message == nil {
+			continue
+		}
+		if role := response.Message.Role; role != "" {
+			message.Role = role
+		}
+		content.WriteString(response.Message.Content)
+	}
+	message.Content = content.String()
+	return &payload.Response{Message: message, Usage: usage}
+}
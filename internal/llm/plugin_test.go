@@ -0,0 +1,122 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+func writePlugin(t *testing.T, script string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	path := filepath.Join(dir, "afa-provider-test")
+	script = "#!/bin/sh\ncat > " + input + "\n" + script
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path, input
+}
+
+func TestPluginClient(t *testing.T) {
+	path, input := writePlugin(t, `echo '{"response":{"message":{"role":"assistant","content":"Hello"}}}'
+echo '{"response":{"message":{"role":"","content":", world"}}}'
+echo '{"response":{"message":{"role":"","content":""},"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}}'
+`)
+	client := NewPluginClient(path)
+	request := &payload.Request{Model: "model-x", Messages: []*payload.Message{{Role: "user", Content: "hi"}}}
+
+	chunks := []string{}
+	err := client.ChatCompletionStream(request, context.Background(), func(response *payload.Response) error {
+		chunks = append(chunks, response.Message.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(chunks, "|") != "Hello|, world|" {
+		t.Errorf("chunks = %q", chunks)
+	}
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pluginRequest PluginRequest
+	if err := json.Unmarshal(data, &pluginRequest); err != nil {
+		t.Fatal(err)
+	}
+	if pluginRequest.Version != PluginProtocolVersion || !pluginRequest.Stream || pluginRequest.Request.Model != "model-x" {
+		t.Errorf("plugin request = %s", data)
+	}
+
+	response, err := client.ChatCompletion(request, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Message.Role != "assistant" || response.Message.Content != "Hello, world" || response.Usage.TotalTokens != 3 {
+		t.Errorf("ChatCompletion() = %+v, %+v", response.Message, response.Usage)
+	}
+}
+
+func TestPluginClientUsageOnlyChunk(t *testing.T) {
+	path, _ := writePlugin(t, `echo '{"response":{"message":{"role":"assistant","content":"hi"}}}'
+echo '{"response":{"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}}'
+`)
+	client := NewPluginClient(path)
+	request := &payload.Request{Model: "model-x", Messages: []*payload.Message{{Role: "user", Content: "hello"}}}
+
+	var content strings.Builder
+	var usage *payload.Usage
+	err := client.ChatCompletionStream(request, context.Background(), func(response *payload.Response) error {
+		content.WriteString(response.Message.Content)
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.String() != "hi" || usage == nil || usage.TotalTokens != 3 {
+		t.Errorf("ChatCompletionStream() = %q, %+v", content.String(), usage)
+	}
+}
+
+func TestPluginClientError(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		err    func(error) bool
+	}{
+		{
+			name:   "error message",
+			script: `echo '{"error":{"type":"rate_limit","status_code":429,"message":"slow down"}}'`,
+			err:    func(err error) bool { return errors.Is(err, ErrRateLimit) && err.Error() == "slow down" },
+		},
+		{
+			name:   "exit status",
+			script: "echo 'gateway is down' >&2\nexit 3",
+			err:    func(err error) bool { return err != nil && strings.Contains(err.Error(), "gateway is down") },
+		},
+		{
+			name:   "invalid message",
+			script: "echo 'not json'",
+			err:    func(err error) bool { return err != nil && strings.Contains(err.Error(), "invalid message") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, _ := writePlugin(t, tt.script)
+			_, err := NewPluginClient(path).ChatCompletion(&payload.Request{Model: "model-x"}, context.Background())
+			if !tt.err(err) {
+				t.Errorf("ChatCompletion() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package markdown
+
+import (
+	"strconv"
+	"strings"
+)
+
+type CodeBlock struct {
+	Index    int
+	Info     string
+	Language string
+	FileName string
+}
+
+// CodeBlockParser extracts fenced code blocks from Markdown text which may arrive in arbitrary chunks.
+// Each content line is passed to OnLine as soon as it is complete, so that code can be streamed.
+type CodeBlockParser struct {
+	OnStart func(*CodeBlock) error
+	OnLine  func(*CodeBlock, string) error
+	OnEnd   func(*CodeBlock) error
+
+	buffer string
+	fence  string
+	block  *CodeBlock
+	count  int
+}
+
+func (p *CodeBlockParser) Write(text string) error {
+	p.buffer += text
+	for {
+		i := strings.IndexByte(p.buffer, '\n')
+		if i < 0 {
+			return nil
+		}
+		line := p.buffer[:i+1]
+		p.buffer = p.buffer[i+1:]
+		if err := p.parseLine(line); err != nil {
+			return err
+		}
+	}
+}
+
+// Close parses the remaining text and closes an unterminated code block.
+func (p *CodeBlockParser) Close() error {
+	if p.buffer != "" {
+		line := p.buffer
+		p.buffer = ""
+		if err := p.parseLine(line); err != nil {
+			return err
+		}
+	}
+	if p.block != nil {
+		return p.end()
+	}
+	return nil
+}
+
+func (p *CodeBlockParser) parseLine(line string) error {
+	trimmed := strings.TrimRight(line, "\r\n")
+	if p.block == nil {
+		fence, info, ok := openingFence(trimmed)
+		if !ok {
+			return nil
+		}
+		language, fileName := parseInfo(info)
+		p.fence = fence
+		p.block = &CodeBlock{
+			Index:    p.count,
+			Info:     info,
+			Language: language,
+			FileName: fileName,
+		}
+		p.count++
+		if p.OnStart != nil {
+			return p.OnStart(p.block)
+		}
+		return nil
+	}
+
+	if isClosingFence(trimmed, p.fence) {
+		return p.end()
+	}
+	if p.OnLine != nil {
+		return p.OnLine(p.block, line)
+	}
+	return nil
+}
+
+func (p *CodeBlockParser) end() error {
+	block := p.block
+	p.block = nil
+	p.fence = ""
+	if p.OnEnd != nil {
+		return p.OnEnd(block)
+	}
+	return nil
+}
+
+func openingFence(line string) (string, string, bool) {
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+	if indent > 3 {
+		return "", "", false
+	}
+	line = line[indent:]
+	for _, c := range []byte{'`', '~'} {
+		n := 0
+		for n < len(line) && line[n] == c {
+			n++
+		}
+		if n < 3 {
+			continue
+		}
+		info := strings.TrimSpace(line[n:])
+		if c == '`' && strings.ContainsRune(info, '`') {
+			return "", "", false
+		}
+		return line[:n], info, true
+	}
+	return "", "", false
+}
+
+func isClosingFence(line, fence string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return false
+	}
+	trimmed = strings.TrimRight(trimmed, " \t")
+	return len(trimmed) >= len(fence) && strings.Trim(trimmed, fence[:1]) == ""
+}
+
+// parseInfo parses the info string of a fence such as "go main.go", "go:main.go",
+// "go file=main.go" or "go title=\"main.go\"".
+func parseInfo(info string) (string, string) {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	language, fileName, _ := strings.Cut(fields[0], ":")
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			if fileName == "" {
+				fileName = field
+			}
+			continue
+		}
+		switch key {
+		case "file", "filename", "title", "path":
+			fileName = strings.Trim(value, "\"'")
+		}
+	}
+	return language, fileName
+}
+
+// Selector selects code blocks by index, by language or all of them.
+type Selector struct {
+	All      bool
+	Index    int
+	Language string
+}
+
+// ParseSelector parses "all", an index starting from 0, or a language name.
+func ParseSelector(s string) *Selector {
+	if s == "all" {
+		return &Selector{All: true}
+	}
+	if i, err := strconv.Atoi(s); err == nil {
+		return &Selector{Index: i}
+	}
+	return &Selector{Index: -1, Language: s}
+}
+
+func (s *Selector) Match(block *CodeBlock) bool {
+	if s.All {
+		return true
+	}
+	if s.Language != "" {
+		return strings.EqualFold(s.Language, block.Language)
+	}
+	return s.Index == block.Index
+}
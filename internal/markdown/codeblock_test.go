@@ -0,0 +1,75 @@
+package markdown
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCodeBlockParser(t *testing.T) {
+	text := "Here is the code.\n\n```go main.go\npackage main\n```\n\nAnd a script:\n\n~~~sh\necho '```'\n~~~\n\n````\n```\nnested\n```\n````\n```python:app.py\nprint(1)"
+
+	type block struct {
+		Index    int
+		Language string
+		FileName string
+		Content  string
+	}
+	expected := []block{
+		{Index: 0, Language: "go", FileName: "main.go", Content: "package main\n"},
+		{Index: 1, Language: "sh", Content: "echo '```'\n"},
+		{Index: 2, Content: "```\nnested\n```\n"},
+		{Index: 3, Language: "python", FileName: "app.py", Content: "print(1)"},
+	}
+
+	// Feed the text in small chunks as in streaming.
+	for _, size := range []int{1, 3, len(text)} {
+		blocks := []block{}
+		var content strings.Builder
+		parser := &CodeBlockParser{
+			OnStart: func(b *CodeBlock) error {
+				content.Reset()
+				return nil
+			},
+			OnLine: func(b *CodeBlock, line string) error {
+				content.WriteString(line)
+				return nil
+			},
+			OnEnd: func(b *CodeBlock) error {
+				blocks = append(blocks, block{Index: b.Index, Language: b.Language, FileName: b.FileName, Content: content.String()})
+				return nil
+			},
+		}
+		for i := 0; i < len(text); i += size {
+			end := min(i+size, len(text))
+			if err := parser.Write(text[i:end]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := parser.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(blocks, expected) {
+			t.Errorf("CodeBlockParser with chunk size %d should extract\n%v\nbut got\n%v", size, expected, blocks)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	block := &CodeBlock{Index: 1, Language: "Go"}
+	tests := []struct {
+		selector string
+		match    bool
+	}{
+		{selector: "all", match: true},
+		{selector: "1", match: true},
+		{selector: "0", match: false},
+		{selector: "go", match: true},
+		{selector: "python", match: false},
+	}
+	for _, tt := range tests {
+		if ParseSelector(tt.selector).Match(block) != tt.match {
+			t.Errorf("Selector %q should return %v", tt.selector, tt.match)
+		}
+	}
+}
@@ -20,4 +20,11 @@ type JsonSchema struct {
 
 type Response struct {
 	Message *Message `json:"message"`
+	Usage   *Usage   `json:"usage,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+// Version is the latest version of the protocol between afa and viewers.
+//
+// Each frame consists of a 4-byte big-endian length followed by a JSON encoded Event.
+// After connecting, the viewer sends a hello event with the versions it supports,
+// and afa replies with a hello event with the negotiated version.
+const Version = 1
+
+var SupportedVersions = []int{Version}
+
+const MaxFrameSize = 16 * 1024 * 1024
+
+const (
+	// Sent by both sides on handshake.
+	EventHello = "hello"
+
+	// Sent by afa.
+	EventSessionInfo  = "session_info"
+	EventMessageStart = "message_start"
+	EventChunk        = "chunk"
+	EventMessageEnd   = "message_end"
+	EventUsage        = "usage"
+	EventPrompt       = "prompt"
+	EventError        = "error"
+	// Tells the viewer whether it holds the input. Only one viewer holds it at a time.
+	EventInputControl = "input_control"
+
+	// Sent by viewers.
+	EventInput = "input"
+)
+
+type Event struct {
+	Type     string         `json:"type"`
+	Version  int            `json:"version,omitempty"`
+	Versions []int          `json:"versions,omitempty"`
+	Role     string         `json:"role,omitempty"`
+	Content  string         `json:"content,omitempty"`
+	Message  string         `json:"message,omitempty"`
+	Granted  bool           `json:"granted,omitempty"`
+	Usage    *payload.Usage `json:"usage,omitempty"`
+	Session  *SessionInfo   `json:"session,omitempty"`
+}
+
+type SessionInfo struct {
+	Name        string `json:"name"`
+	Model       string `json:"model"`
+	Schema      string `json:"schema,omitempty"`
+	Interactive bool   `json:"interactive"`
+	Stream      bool   `json:"stream"`
+}
+
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+func WriteEvent(w io.Writer, event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxFrameSize {
+		return fmt.Errorf("frame size %d exceeds the limit %d", len(data), MaxFrameSize)
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+func ReadEvent(r io.Reader) (*Event, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrameSize {
+		return nil, fmt.Errorf("frame size %d exceeds the limit %d", size, MaxFrameSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// AcceptHandshake waits for the hello event from a viewer and replies with the negotiated version.
+func AcceptHandshake(rw io.ReadWriter) (int, error) {
+	event, err := ReadEvent(rw)
+	if err != nil {
+		return 0, err
+	}
+	if event.Type != EventHello {
+		return 0, fmt.Errorf("expected %s event, but got %s", EventHello, event.Type)
+	}
+	version := negotiate(event.Versions)
+	if version == 0 {
+		WriteEvent(rw, &Event{
+			Type:    EventError,
+			Message: fmt.Sprintf("%v: afa supports %v", ErrUnsupportedVersion, SupportedVersions),
+		})
+		return 0, ErrUnsupportedVersion
+	}
+	return version, WriteEvent(rw, &Event{Type: EventHello, Version: version})
+}
+
+// Handshake sends the hello event as a viewer and returns the negotiated version.
+func Handshake(rw io.ReadWriter) (int, error) {
+	if err := WriteEvent(rw, &Event{Type: EventHello, Versions: SupportedVersions}); err != nil {
+		return 0, err
+	}
+	event, err := ReadEvent(rw)
+	if err != nil {
+		return 0, err
+	}
+	switch event.Type {
+	case EventHello:
+		if !slices.Contains(SupportedVersions, event.Version) {
+			return 0, ErrUnsupportedVersion
+		}
+		return event.Version, nil
+	case EventError:
+		return 0, errors.New(event.Message)
+	}
+	return 0, fmt.Errorf("expected %s event, but got %s", EventHello, event.Type)
+}
+
+func negotiate(versions []int) int {
+	version := 0
+	for _, v := range versions {
+		if v > version && slices.Contains(SupportedVersions, v) {
+			version = v
+		}
+	}
+	return version
+}
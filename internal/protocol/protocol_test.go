@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+func TestReadWriteEvent(t *testing.T) {
+	events := []*Event{
+		{Type: EventSessionInfo, Session: &SessionInfo{Name: "session", Model: "model"}},
+		{Type: EventMessageStart, Role: "assistant"},
+		{Type: EventChunk, Content: "__AFA_PROMPT__ and __AFA_ERROR__ are just text."},
+		{Type: EventMessageEnd, Role: "assistant"},
+		{Type: EventUsage, Usage: &payload.Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3}},
+		{Type: EventError, Message: "something went wrong"},
+	}
+
+	var buf bytes.Buffer
+	for _, event := range events {
+		if err := WriteEvent(&buf, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range events {
+		event, err := ReadEvent(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(event, expected) {
+			t.Errorf("ReadEvent should return %v, but got %v", expected, event)
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := AcceptHandshake(server)
+		errChan <- err
+	}()
+
+	version, err := Handshake(client)
+	if err != nil {
+		t.Fatalf("Handshake should not return error, but got %v", err)
+	}
+	if version != Version {
+		t.Errorf("Handshake should negotiate version %d, but got %d", Version, version)
+	}
+	if err := <-errChan; err != nil {
+		t.Errorf("AcceptHandshake should not return error, but got %v", err)
+	}
+}
+
+func TestHandshakeUnsupportedVersion(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		WriteEvent(client, &Event{Type: EventHello, Versions: []int{Version + 1}})
+		ReadEvent(client)
+	}()
+
+	if _, err := AcceptHandshake(server); err != ErrUnsupportedVersion {
+		t.Errorf("AcceptHandshake should return ErrUnsupportedVersion, but got %v", err)
+	}
+}
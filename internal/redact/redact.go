@@ -0,0 +1,177 @@
+// Package redact finds credentials in text.
+package redact
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Rule finds credentials by the pattern. If the pattern has a group, only the first group is the credential.
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+var DefaultRules = []*Rule{
+	{Name: "private_key", Pattern: regexp.MustCompile(`-----BEGIN[A-Z ]*PRIVATE KEY-----[\s\S]*?-----END[A-Z ]*PRIVATE KEY-----`)},
+	{Name: "aws_access_key_id", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|AIDA|AROA)[0-9A-Z]{16}\b`)},
+	{Name: "aws_secret_access_key", Pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`)},
+	{Name: "github_token", Pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+	{Name: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+}
+
+const (
+	entropyRule = "high_entropy"
+	// Strings shorter than this are not checked for the entropy. (e.g. hashes of git commits have 40 characters)
+	entropyMinLength = 32
+	// DefaultMinEntropy finds most random alphanumeric strings. Hexadecimal strings have 4 bits per character at most.
+	DefaultMinEntropy = 4.3
+)
+
+var entropyCandidate = regexp.MustCompile(`[A-Za-z0-9+/_\-]{32,}={0,2}`)
+
+// Finding is a credential found in the text. Start and End are byte offsets.
+type Finding struct {
+	Rule  string
+	Line  int
+	Start int
+	End   int
+	Match string
+}
+
+// Masked returns the match with only the first characters.
+func (f *Finding) Masked() string {
+	first, _, _ := strings.Cut(f.Match, "\n")
+	if len(first) > 4 {
+		first = first[:4]
+	}
+	return first + strings.Repeat("*", min(len(f.Match)-len(first), 12))
+}
+
+type Scanner struct {
+	Rules []*Rule
+	// MinEntropy finds random strings with the bits per character or more. 0 disables it.
+	MinEntropy float64
+}
+
+// NewScanner returns the scanner with the default rules and the patterns by name.
+func NewScanner(patterns map[string]string, minEntropy float64) (*Scanner, error) {
+	rules := append([]*Rule{}, DefaultRules...)
+	names := make([]string, 0, len(patterns))
+	for name := range patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pattern, err := regexp.Compile(patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern of %s. %v", name, err)
+		}
+		rules = append(rules, &Rule{Name: name, Pattern: pattern})
+	}
+	return &Scanner{Rules: rules, MinEntropy: minEntropy}, nil
+}
+
+// Scan returns the findings that do not overlap, in order of the position.
+func (s *Scanner) Scan(text string) []*Finding {
+	findings := []*Finding{}
+	for _, rule := range s.Rules {
+		for _, loc := range rule.Pattern.FindAllStringSubmatchIndex(text, -1) {
+			start, end := loc[0], loc[1]
+			if len(loc) >= 4 && loc[2] >= 0 {
+				start, end = loc[2], loc[3]
+			}
+			if start < end {
+				findings = append(findings, &Finding{Rule: rule.Name, Start: start, End: end})
+			}
+		}
+	}
+	findings = removeOverlaps(findings)
+	if s.MinEntropy > 0 {
+		// Rules take precedence over the entropy.
+		matched := findings
+		for _, loc := range entropyCandidate.FindAllStringIndex(text, -1) {
+			if overlaps(matched, loc[0], loc[1]) || !isRandom(text[loc[0]:loc[1]], s.MinEntropy) {
+				continue
+			}
+			findings = append(findings, &Finding{Rule: entropyRule, Start: loc[0], End: loc[1]})
+		}
+		findings = removeOverlaps(findings)
+	}
+
+	for _, finding := range findings {
+		finding.Line = strings.Count(text[:finding.Start], "\n") + 1
+		finding.Match = text[finding.Start:finding.End]
+	}
+	return findings
+}
+
+// removeOverlaps keeps earlier and longer findings. Of the same range, the first one is kept.
+func removeOverlaps(findings []*Finding) []*Finding {
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Start != findings[j].Start {
+			return findings[i].Start < findings[j].Start
+		}
+		return findings[i].End > findings[j].End
+	})
+	result := []*Finding{}
+	end := 0
+	for _, finding := range findings {
+		if finding.Start < end {
+			continue
+		}
+		result = append(result, finding)
+		end = finding.End
+	}
+	return result
+}
+
+func overlaps(findings []*Finding, start, end int) bool {
+	for _, finding := range findings {
+		if start < finding.End && finding.Start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces the findings in the text with their rule names.
+func Redact(text string, findings []*Finding) string {
+	var buf strings.Builder
+	last := 0
+	for _, finding := range findings {
+		buf.WriteString(text[last:finding.Start])
+		fmt.Fprintf(&buf, "[REDACTED:%s]", finding.Rule)
+		last = finding.End
+	}
+	buf.WriteString(text[last:])
+	return buf.String()
+}
+
+// isRandom reports whether the string mixes letters and digits with the entropy or more.
+func isRandom(s string, minEntropy float64) bool {
+	if len(s) < entropyMinLength || !strings.ContainsAny(s, "0123456789") {
+		return false
+	}
+	if strings.IndexFunc(s, func(r rune) bool { return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' }) < 0 {
+		return false
+	}
+	return entropy(s) >= minEntropy
+}
+
+// entropy returns the Shannon entropy in bits per character.
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	var h float64
+	for _, count := range counts {
+		p := float64(count) / float64(len(s))
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -0,0 +1,209 @@
+// Package trace records HTTP exchanges with the provider as JSON lines for debugging.
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeRequest  = "request"
+	TypeResponse = "response"
+	TypeEvent    = "event"
+	TypeError    = "error"
+	TypeCache    = "cache"
+	TypeDone     = "done"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are redacted, since trace files are attached to bug reports.
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"api-key":             true,
+	"x-api-key":           true,
+	"cookie":              true,
+	"set-cookie":          true,
+}
+
+type Record struct {
+	Time      time.Time           `json:"time"`
+	Exchange  int                 `json:"exchange"`
+	Type      string              `json:"type"`
+	ElapsedMs float64             `json:"elapsed_ms"`
+	Method    string              `json:"method,omitempty"`
+	URL       string              `json:"url,omitempty"`
+	Status    int                 `json:"status,omitempty"`
+	Header    map[string][]string `json:"header,omitempty"`
+	Body      json.RawMessage     `json:"body,omitempty"`
+	Text      string              `json:"text,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	Cache     string              `json:"cache,omitempty"`
+	Key       string              `json:"key,omitempty"`
+}
+
+type Tracer struct {
+	mu        sync.Mutex
+	w         io.Writer
+	closer    io.Closer
+	exchanges int
+}
+
+func New(w io.Writer) *Tracer {
+	return &Tracer{w: w}
+}
+
+// Open appends the records to the file.
+func Open(path string) (*Tracer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Tracer{w: file, closer: file}, nil
+}
+
+func (t *Tracer) Close() error {
+	if t == nil || t.closer == nil {
+		return nil
+	}
+	return t.closer.Close()
+}
+
+// Start begins an exchange. Exchange is nil if the tracer is nil, and its methods do nothing.
+func (t *Tracer) Start() *Exchange {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	t.exchanges++
+	id := t.exchanges
+	t.mu.Unlock()
+	return &Exchange{tracer: t, id: id, start: time.Now()}
+}
+
+func (t *Tracer) write(record *Record) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(append(data, '\n'))
+}
+
+type Exchange struct {
+	tracer *Tracer
+	id     int
+	start  time.Time
+}
+
+func (e *Exchange) Request(req *http.Request, body []byte) {
+	if e == nil {
+		return
+	}
+	e.write(&Record{
+		Type:   TypeRequest,
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: redactHeader(req.Header),
+		Body:   rawJSON(body),
+		Text:   textIfNotJSON(body),
+	})
+}
+
+// Response records the status and headers, and the body if it has been read.
+func (e *Exchange) Response(resp *http.Response, body []byte) {
+	if e == nil {
+		return
+	}
+	e.write(&Record{
+		Type:   TypeResponse,
+		Status: resp.StatusCode,
+		Header: redactHeader(resp.Header),
+		Body:   rawJSON(body),
+		Text:   textIfNotJSON(body),
+	})
+}
+
+// Event records a server-sent event line.
+func (e *Exchange) Event(line []byte) {
+	if e == nil {
+		return
+	}
+	e.write(&Record{Type: TypeEvent, Text: string(line)})
+}
+
+func (e *Exchange) Error(err error) {
+	if e == nil || err == nil {
+		return
+	}
+	e.write(&Record{Type: TypeError, Error: err.Error()})
+}
+
+// Cache records the status of the response cache. (hit, miss or store)
+func (e *Exchange) Cache(status, key string) {
+	if e == nil {
+		return
+	}
+	e.write(&Record{Type: TypeCache, Cache: status, Key: key})
+}
+
+func (e *Exchange) Done() {
+	if e == nil {
+		return
+	}
+	e.write(&Record{Type: TypeDone})
+}
+
+func (e *Exchange) write(record *Record) {
+	now := time.Now()
+	record.Time = now
+	record.Exchange = e.id
+	record.ElapsedMs = float64(now.Sub(e.start).Microseconds()) / 1000
+	e.tracer.write(record)
+}
+
+func redactHeader(header http.Header) map[string][]string {
+	redactedHeader := map[string][]string{}
+	for key, values := range header {
+		if sensitiveHeaders[strings.ToLower(key)] {
+			redactedHeader[key] = []string{redacted}
+			continue
+		}
+		redactedHeader[key] = values
+	}
+	return redactedHeader
+}
+
+func rawJSON(body []byte) json.RawMessage {
+	if len(body) == 0 || !json.Valid(body) {
+		return nil
+	}
+	return json.RawMessage(body)
+}
+
+func textIfNotJSON(body []byte) string {
+	if len(body) == 0 || json.Valid(body) {
+		return ""
+	}
+	return string(body)
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, tracer *Tracer) context.Context {
+	return context.WithValue(ctx, contextKey{}, tracer)
+}
+
+// FromContext returns the tracer in the context, or nil.
+func FromContext(ctx context.Context) *Tracer {
+	tracer, _ := ctx.Value(contextKey{}).(*Tracer)
+	return tracer
+}
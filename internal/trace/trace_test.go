@@ -0,0 +1,54 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExchange(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := New(&buf)
+	ctx := NewContext(context.Background(), tracer)
+
+	exchange := FromContext(ctx).Start()
+	req, err := http.NewRequest("POST", "https://example.com/v1/chat/completions", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	exchange.Request(req, []byte(`{"model":"m"}`))
+	exchange.Response(&http.Response{StatusCode: 200, Header: http.Header{}}, nil)
+	exchange.Event([]byte(`data: {"choices":[]}`))
+	exchange.Error(errors.New("failed"))
+	exchange.Done()
+
+	if strings.Contains(buf.String(), "sk-secret") {
+		t.Errorf("API key should be redacted: %s", buf.String())
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	types := []string{TypeRequest, TypeResponse, TypeEvent, TypeError, TypeDone}
+	if len(lines) != len(types) {
+		t.Fatalf("got %d records, want %d", len(lines), len(types))
+	}
+	for i, line := range lines {
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Type != types[i] || record.Exchange != 1 {
+			t.Errorf("record %d = %s of exchange %d, want %s of exchange 1", i, record.Type, record.Exchange, types[i])
+		}
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	// Tracing is disabled without a tracer in the context.
+	exchange := FromContext(context.Background()).Start()
+	exchange.Event([]byte("data: [DONE]"))
+	exchange.Done()
+}
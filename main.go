@@ -5,37 +5,78 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"os/exec"
+	"slices"
 	"strings"
 )
 
 const cmdName = "afa"
 
 func main() {
+	args, errorFormat, err := extractErrorFormat(os.Args[1:])
+	if err != nil {
+		os.Exit(reportError(os.Stderr, errorFormatText, "Failed to parse flags.", err))
+	}
+	fatal := func(prefix string, err error) {
+		os.Exit(reportError(os.Stderr, errorFormat, prefix, err))
+	}
+
 	initCommand, err := GetInitCommand()
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error: Failed to get init command. %v", err))
+		fatal("Failed to get init command.", err)
 	}
 	newCommand, err := GetNewCommand()
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error: Failed to get new command. %v", err))
+		fatal("Failed to get new command.", err)
 	}
 	sourceCommand, err := GetSourceCommand()
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error: Failed to get source command. %v", err))
+		fatal("Failed to get source command.", err)
 	}
 	resumeCommand, err := GetResumeCommand()
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error: Failed to get resume command. %v", err))
+		fatal("Failed to get resume command.", err)
 	}
 	listCommand, err := GetListCommand()
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error: Failed to get list command. %v", err))
+		fatal("Failed to get list command.", err)
 	}
 	showCommand, err := GetShowCommand()
 	if err != nil {
-		log.Fatal(fmt.Sprintf("Error: Failed to get show command. %v", err))
+		fatal("Failed to get show command.", err)
+	}
+	attachCommand, err := GetAttachCommand()
+	if err != nil {
+		fatal("Failed to get attach command.", err)
+	}
+	psCommand, err := GetPsCommand()
+	if err != nil {
+		fatal("Failed to get ps command.", err)
+	}
+	killCommand, err := GetKillCommand()
+	if err != nil {
+		fatal("Failed to get kill command.", err)
+	}
+	serveCommand, err := GetServeCommand()
+	if err != nil {
+		fatal("Failed to get serve command.", err)
+	}
+	templatesCommand, err := GetTemplatesCommand()
+	if err != nil {
+		fatal("Failed to get templates command.", err)
+	}
+	schemasCommand, err := GetSchemasCommand()
+	if err != nil {
+		fatal("Failed to get schemas command.", err)
+	}
+	cacheCommand, err := GetCacheCommand()
+	if err != nil {
+		fatal("Failed to get cache command.", err)
+	}
+	encryptionCommand, err := GetEncryptionCommand()
+	if err != nil {
+		fatal("Failed to get encryption command.", err)
 	}
 
 	cmds := []Command{
@@ -45,6 +86,14 @@ func main() {
 		resumeCommand,
 		listCommand,
 		showCommand,
+		attachCommand,
+		psCommand,
+		killCommand,
+		serveCommand,
+		templatesCommand,
+		schemasCommand,
+		cacheCommand,
+		encryptionCommand,
 	}
 
 	defaultSubCommandIdx := 0
@@ -55,7 +104,6 @@ func main() {
 	}
 	defaultSubCommand := []string{cmds[defaultSubCommandIdx].Name(), "-script"}
 
-	args := os.Args[1:]
 	if len(args) == 0 {
 		args = defaultSubCommand
 	}
@@ -65,6 +113,8 @@ func main() {
 	flagSetOutput := flagSet.Output()
 	flagSet.SetOutput(io.Discard)
 	ver := flagSet.Bool("version", false, "Display version")
+	// The error format is extracted in advance. This is for the usage.
+	flagSet.String("error-format", errorFormatText, "Format of errors on standard error. (text or json)")
 	if err := flagSet.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			fmt.Fprintf(flagSetOutput, "Usage of %s:\n", cmdName)
@@ -76,6 +126,7 @@ func main() {
 				}
 				fmt.Fprintf(flagSetOutput, "  %s\n\t%s%s\n", cmd.Name(), cmd.Description(), isDefaultSubCommand)
 			}
+			printExternalCommands(flagSetOutput, cmdNames(cmds))
 			os.Exit(0)
 		}
 		// No subcommand and unknown flag
@@ -86,13 +137,10 @@ func main() {
 		os.Exit(0)
 	}
 
-	names := []string{}
-	for _, cmd := range cmds {
-		names = append(names, cmd.Name())
-	}
+	names := cmdNames(cmds)
 
 	if len(os.Args) == 1 {
-		log.Fatal(subCommandNotFoundError(names))
+		fatal("", subCommandNotFoundError(names))
 	}
 
 	subCommand := args[0]
@@ -100,23 +148,51 @@ func main() {
 	for _, cmd := range cmds {
 		if cmd.Name() == subCommand {
 			if err := cmd.Parse(args[1:]); err != nil {
-				log.Fatal(fmt.Sprintf("Error: Failed to parse flags. %v", err))
+				if errors.Is(err, flag.ErrHelp) {
+					os.Exit(0)
+				}
+				fatal("Failed to parse flags.", &UsageError{err})
 			}
 			if err := cmd.Run(); err != nil {
-				log.Fatal(fmt.Sprintf("Error: Failed to run. %v", err))
+				fatal("Failed to run.", err)
 			}
 			match = true
 		}
 	}
 
 	if !match {
-		log.Fatal(subCommandNotFoundError(names))
+		// Like git, "afa foo" runs afa-foo on PATH.
+		external, err := GetExternalCommand(subCommand)
+		if errors.Is(err, exec.ErrNotFound) {
+			fatal("", subCommandNotFoundError(names))
+		}
+		if err != nil {
+			fatal("Failed to get external command.", err)
+		}
+		// Arguments are passed as they are, including the error format.
+		external.Parse(os.Args[slices.Index(os.Args[1:], subCommand)+2:])
+		if err := external.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				// The external command reports its own errors.
+				os.Exit(exitErr.ExitCode())
+			}
+			fatal("Failed to run.", err)
+		}
+	}
+}
+
+func cmdNames(cmds []Command) []string {
+	names := []string{}
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name())
 	}
+	return names
 }
 
 func subCommandNotFoundError(subcommands []string) error {
-	return fmt.Errorf(
-		"Error: No subcommand specified. Please provide one of the following subcommands: %s.",
+	return &UsageError{fmt.Errorf(
+		"No subcommand specified. Please provide one of the following subcommands: %s.",
 		strings.Join(subcommands, ", "),
-	)
+	)}
 }
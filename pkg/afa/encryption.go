@@ -0,0 +1,183 @@
+package afa
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptionMagic starts encrypted files, followed by the salt, the nonce and the sealed box.
+const encryptionMagic = "afa-encrypted:v1\n"
+
+const (
+	saltSize  = 16
+	nonceSize = 24
+	keySize   = 32
+)
+
+// encryptionCheck is encrypted in the salt file to find a wrong passphrase before files are written with it.
+const encryptionCheck = "afa"
+
+var (
+	// ErrDecrypt is returned when the key does not open an encrypted file.
+	ErrDecrypt = errors.New("Failed to decrypt. The passphrase or the key file may be wrong.")
+	// ErrEncryptionDisabled is returned when the workspace does not enable the encryption.
+	ErrEncryptionDisabled = errors.New("Encryption is not enabled. Please set encryption.enabled in option.json.")
+)
+
+// Cipher encrypts files with NaCl secretbox. The key is derived by scrypt from the passphrase or the content of the key file.
+type Cipher struct {
+	// Secret returns the passphrase or the content of the key file. It is called once when it is needed first.
+	Secret func() ([]byte, error)
+	// Salt returns the salt of new files. Each file has its salt, so files with another salt can be decrypted.
+	Salt func() ([]byte, error)
+
+	saltMu sync.Mutex
+	salt   []byte
+
+	mu     sync.Mutex
+	secret []byte
+	keys   map[string]*[keySize]byte
+}
+
+func NewCipher(secret, salt func() ([]byte, error)) *Cipher {
+	return &Cipher{Secret: secret, Salt: salt, keys: map[string]*[keySize]byte{}}
+}
+
+// IsEncrypted reports whether the data is encrypted by Cipher.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptionMagic))
+}
+
+func (c *Cipher) Encrypt(plain []byte) ([]byte, error) {
+	c.saltMu.Lock()
+	if c.salt == nil {
+		salt, err := c.Salt()
+		if err != nil {
+			c.saltMu.Unlock()
+			return nil, err
+		}
+		c.salt = salt
+	}
+	salt := c.salt
+	c.saltMu.Unlock()
+	return c.seal(plain, salt)
+}
+
+func (c *Cipher) seal(plain, salt []byte) ([]byte, error) {
+	key, err := c.key(salt)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [nonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	out := append([]byte(encryptionMagic), salt...)
+	out = append(out, nonce[:]...)
+	return secretbox.Seal(out, plain, &nonce, key), nil
+}
+
+// Decrypt returns data that is not encrypted as it is, such as files written before the encryption is enabled.
+func (c *Cipher) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	data = data[len(encryptionMagic):]
+	if len(data) < saltSize+nonceSize+secretbox.Overhead {
+		return nil, ErrDecrypt
+	}
+	key, err := c.key(data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], data[saltSize:saltSize+nonceSize])
+	plain, ok := secretbox.Open(nil, data[saltSize+nonceSize:], &nonce, key)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// key derives the key for the salt, which is cached since scrypt is slow on purpose.
+func (c *Cipher) key(salt []byte) (*[keySize]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[string(salt)]; ok {
+		return key, nil
+	}
+	if c.secret == nil {
+		secret, err := c.Secret()
+		if err != nil {
+			return nil, err
+		}
+		if len(secret) == 0 {
+			return nil, errors.New("The passphrase or the key file is empty.")
+		}
+		c.secret = secret
+	}
+	derived, err := scrypt.Key(c.secret, salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+	key := new([keySize]byte)
+	copy(key[:], derived)
+	c.keys[string(salt)] = key
+	return key, nil
+}
+
+// NewSalt returns a random salt.
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// EncryptedStorage encrypts the files that Encrypted reports, and decrypts them on Open.
+type EncryptedStorage struct {
+	Storage
+	Cipher    *Cipher
+	Encrypted func(name string) bool
+}
+
+func (s *EncryptedStorage) Open(name string) (fs.File, error) {
+	file, err := s.Storage.Open(name)
+	if err != nil || !s.Encrypted(name) {
+		return file, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return file, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := s.Cipher.Decrypt(data)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	plainInfo := &memoryFileInfo{name: info.Name(), data: plain, mode: info.Mode(), modTime: info.ModTime()}
+	return &memoryFile{info: plainInfo, Reader: bytes.NewReader(plain)}, nil
+}
+
+func (s *EncryptedStorage) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !s.Encrypted(name) {
+		return s.Storage.WriteFile(name, data, perm)
+	}
+	encrypted, err := s.Cipher.Encrypt(data)
+	if err != nil {
+		return err
+	}
+	return s.Storage.WriteFile(name, encrypted, perm)
+}
@@ -0,0 +1,92 @@
+package afa
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"testing"
+)
+
+func passphrase(s string) func() ([]byte, error) {
+	return func() ([]byte, error) { return []byte(s), nil }
+}
+
+func TestCipher(t *testing.T) {
+	cipher := NewCipher(passphrase("correct"), NewSalt)
+	encrypted, err := cipher.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || bytes.Contains(encrypted, []byte("hello")) {
+		t.Fatalf("Encrypt() = %q, want encrypted data", encrypted)
+	}
+	if plain, err := cipher.Decrypt(encrypted); err != nil || string(plain) != "hello" {
+		t.Errorf("Decrypt() = %q, %v, want hello", plain, err)
+	}
+	if plain, err := cipher.Decrypt([]byte("{}")); err != nil || string(plain) != "{}" {
+		t.Errorf("Decrypt() of plain data = %q, %v, want it as it is", plain, err)
+	}
+
+	wrong := NewCipher(passphrase("wrong"), NewSalt)
+	if _, err := wrong.Decrypt(encrypted); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Decrypt() with a wrong passphrase error = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestWorkSpaceWithEncryption(t *testing.T) {
+	t.Setenv(OpenAIAPIKeyEnv, "")
+	storage := NewMemoryStorage()
+	workSpace := NewWorkSpaceWithStorage(storage, t.TempDir())
+	if err := workSpace.Setup(NewOption(), NewSecret("sk-plain")); err != nil {
+		t.Fatal(err)
+	}
+	if err := workSpace.SaveHistory("old", NewHistory("gpt-4o-mini", "", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	workSpace.EnableEncryption(passphrase("correct"))
+	if err := workSpace.SaveHistory("new", NewHistory("gpt-4o-mini", "", nil)); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := fs.ReadFile(storage, path.Join(workSpace.SessionsDir(), "new.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(raw) {
+		t.Errorf("SaveHistory() should encrypt the session")
+	}
+	names, _, err := workSpace.ListSessions(10, false)
+	if err != nil || len(names) != 2 {
+		t.Fatalf("ListSessions() = %v, %v, want both of the plain and encrypted sessions", names, err)
+	}
+
+	encrypted, err := workSpace.EncryptFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted) != 2 {
+		t.Errorf("EncryptFiles() = %v, want the secret and the old session", encrypted)
+	}
+	secret, err := workSpace.LoadSecret()
+	if err != nil || secret.OpenAI.ApiKey != "sk-plain" {
+		t.Errorf("LoadSecret() = %v, %v, want the decrypted secret", secret, err)
+	}
+
+	other := NewWorkSpaceWithStorage(storage, t.TempDir())
+	other.EnableEncryption(passphrase("wrong"))
+	if _, err := other.LoadHistory(path.Join(other.SessionsDir(), "old.json")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("LoadHistory() with a wrong passphrase error = %v, want ErrDecrypt", err)
+	}
+	if err := other.SaveHistory("another", NewHistory("gpt-4o-mini", "", nil)); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("SaveHistory() with a wrong passphrase error = %v, want ErrDecrypt", err)
+	}
+
+	decrypted, err := workSpace.DecryptFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, plain, err := workSpace.EncryptionFiles(); err != nil || len(plain) != len(decrypted) || len(plain) != 3 {
+		t.Errorf("EncryptionFiles() after DecryptFiles = %v, %v, want all files plain", plain, err)
+	}
+}
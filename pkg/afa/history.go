@@ -1,4 +1,4 @@
-package main
+package afa
 
 import (
 	"bytes"
@@ -10,6 +10,7 @@ import (
 
 type History struct {
 	*payload.Request
+	Usage *payload.Usage `json:"usage,omitempty"`
 }
 
 type HistoryMessage struct {
@@ -27,7 +28,7 @@ func NewHistory(model, schema string, rawSchema *json.RawMessage) *History {
 			Schema: rawSchema,
 		}
 	}
-	return &History{request}
+	return &History{Request: request}
 }
 
 func (h *History) IsNewSession() bool {
@@ -38,6 +39,18 @@ func (h *History) AddMessage(role, content string) {
 	h.Messages = append(h.Messages, &payload.Message{Role: role, Content: content})
 }
 
+func (h *History) AddUsage(usage *payload.Usage) {
+	if usage == nil {
+		return
+	}
+	if h.Usage == nil {
+		h.Usage = &payload.Usage{}
+	}
+	h.Usage.PromptTokens += usage.PromptTokens
+	h.Usage.CompletionTokens += usage.CompletionTokens
+	h.Usage.TotalTokens += usage.TotalTokens
+}
+
 func (h *History) RemoveLastMessage() {
 	h.Messages = h.Messages[:len(h.Messages)-1]
 }
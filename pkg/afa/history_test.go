@@ -0,0 +1,43 @@
+package afa
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+func TestIsNewSession(t *testing.T) {
+	hist := NewHistory("", "", nil)
+	if !hist.IsNewSession() {
+		t.Errorf("IsNewSession should return false for a new History instance")
+	}
+
+	hist.AddMessage("", "")
+	if hist.IsNewSession() {
+		t.Errorf("IsNewSession should return true after a message is added")
+	}
+}
+
+func TestAddUsage(t *testing.T) {
+	hist := NewHistory("", "", nil)
+	hist.AddUsage(nil)
+	if hist.Usage != nil {
+		t.Errorf("AddUsage should ignore nil usage")
+	}
+
+	hist.AddUsage(&payload.Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3})
+	hist.AddUsage(&payload.Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3})
+
+	data, err := json.Marshal(hist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded History
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Usage == nil || loaded.Usage.TotalTokens != 6 {
+		t.Errorf("AddUsage should accumulate usage, but got %v", loaded.Usage)
+	}
+}
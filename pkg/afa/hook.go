@@ -0,0 +1,124 @@
+package afa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+type HookStage string
+
+const (
+	HookSessionStart HookStage = "session_start"
+	HookPreRequest   HookStage = "pre_request"
+	HookPostResponse HookStage = "post_response"
+	HookSessionSave  HookStage = "session_save"
+)
+
+// HookInput is written to the standard input of a hook as JSON.
+type HookInput struct {
+	Stage    HookStage `json:"stage"`
+	Session  string    `json:"session"`
+	Request  *Request  `json:"request"`
+	Response *Response `json:"response,omitempty"`
+}
+
+// HookOutput is read from the standard output of a hook. Empty output keeps the input as it is.
+type HookOutput struct {
+	Request  *Request  `json:"request,omitempty"`
+	Response *Response `json:"response,omitempty"`
+	Reject   string    `json:"reject,omitempty"`
+}
+
+// HookRejectedError is returned when a hook rejects the request or the response.
+type HookRejectedError struct {
+	Stage   HookStage
+	Command string
+	Reason  string
+}
+
+func (e *HookRejectedError) Error() string {
+	return fmt.Sprintf("Rejected by the %s hook %q. %s", e.Stage, e.Command, e.Reason)
+}
+
+// Hooks runs the commands of the stages in order. Each command receives the output of the previous one.
+type Hooks struct {
+	Option  *HooksOption
+	Session string
+}
+
+func NewHooks(option *HooksOption, session string) *Hooks {
+	return &Hooks{Option: option, Session: session}
+}
+
+func (h *Hooks) Run(ctx context.Context, stage HookStage, request *Request, response *Response) (*Request, *Response, error) {
+	if h == nil || h.Option == nil {
+		return request, response, nil
+	}
+	for _, command := range h.Option.Commands(stage) {
+		if len(command) == 0 {
+			continue
+		}
+		output, err := h.run(ctx, command, &HookInput{Stage: stage, Session: h.Session, Request: request, Response: response})
+		if err != nil {
+			return nil, nil, err
+		}
+		name := strings.Join(command, " ")
+		if output.Reject != "" {
+			return nil, nil, &HookRejectedError{Stage: stage, Command: name, Reason: output.Reject}
+		}
+		if output.Request != nil {
+			request = output.Request
+		}
+		if output.Response != nil {
+			response = output.Response
+		}
+	}
+	return request, response, nil
+}
+
+// Has reports whether any command runs at the stage.
+func (h *Hooks) Has(stage HookStage) bool {
+	return h != nil && h.Option != nil && len(h.Option.Commands(stage)) > 0
+}
+
+func (h *Hooks) run(ctx context.Context, command []string, input *HookInput) (*HookOutput, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.Join(command, " ")
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && ctx.Err() == nil {
+			// A hook that exits with non-zero status rejects with its standard error.
+			reason := strings.TrimSpace(stderr.String())
+			if reason == "" {
+				reason = exitErr.Error()
+			}
+			return nil, &HookRejectedError{Stage: input.Stage, Command: name, Reason: reason}
+		}
+		return nil, fmt.Errorf("Failed to run the %s hook %q. %v", input.Stage, name, err)
+	}
+
+	var output HookOutput
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return &output, nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("The %s hook %q wrote invalid output. %v", input.Stage, name, err)
+	}
+	if output.Response != nil && output.Response.Message == nil {
+		return nil, fmt.Errorf("The %s hook %q wrote a response without the message.", input.Stage, name)
+	}
+	return &output, nil
+}
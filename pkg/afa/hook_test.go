@@ -0,0 +1,116 @@
+package afa
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeHook(t *testing.T, dir, name, script string) []string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return []string{path}
+}
+
+func TestHooksRun(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.json")
+	tests := []struct {
+		name    string
+		scripts []string
+		content string
+		reason  string
+	}{
+		{
+			name:    "keep",
+			scripts: []string{"cat > " + input},
+			content: "hi",
+		},
+		{
+			name: "modify in order",
+			scripts: []string{
+				`cat > /dev/null; echo '{"request":{"model":"model","messages":[{"role":"user","content":"first"}]}}'`,
+				`grep -q first && echo '{"request":{"model":"model","messages":[{"role":"user","content":"second"}]}}'`,
+			},
+			content: "second",
+		},
+		{
+			name:    "reject",
+			scripts: []string{`cat > /dev/null; echo '{"reject":"model is blocked"}'`},
+			reason:  "model is blocked",
+		},
+		{
+			name:    "exit status",
+			scripts: []string{"cat > /dev/null; echo 'secret found' >&2; exit 1"},
+			reason:  "secret found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			option := &HooksOption{}
+			for i, script := range tt.scripts {
+				option.PreRequest = append(option.PreRequest, writeHook(t, dir, strings.ReplaceAll(tt.name, " ", "-")+string(rune('0'+i)), script))
+			}
+			request := &Request{Model: "model", Messages: []*Message{{Role: "user", Content: "hi"}}}
+			got, _, err := NewHooks(option, "session").Run(context.Background(), HookPreRequest, request, nil)
+
+			var rerr *HookRejectedError
+			if tt.reason != "" {
+				if !errors.As(err, &rerr) || rerr.Reason != tt.reason || rerr.Stage != HookPreRequest {
+					t.Errorf("Run() error = %v, want rejected with %q", err, tt.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Messages[0].Content != tt.content {
+				t.Errorf("Run() content = %q, want %q", got.Messages[0].Content, tt.content)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"stage":"pre_request","session":"session"`)) {
+		t.Errorf("hook input = %s", data)
+	}
+}
+
+func TestSessionHooks(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewMemoryStorage()
+	if err := storage.WriteFile("prompt.tmpl", []byte("{{ .Message }}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	option := &HooksOption{
+		PostResponse: [][]string{writeHook(t, dir, "post", `cat > /dev/null; echo '{"response":{"message":{"role":"assistant","content":"[REDACTED]"}}}'`)},
+	}
+
+	// Chunks are not printed in stream mode, so that the hooks can redact them.
+	for _, stream := range []bool{false, true} {
+		session, err := NewSession(NewSecret(""), NewHistory("model", "", nil), storage, SessionOptions{SystemPromptTemplate: "prompt.tmpl", UserPromptTemplate: "prompt.tmpl", Stream: stream})
+		if err != nil {
+			t.Fatal(err)
+		}
+		session.Client = &stubClient{responses: []string{"AKIA0000"}}
+		session.Hooks = NewHooks(option, "session")
+
+		var buf bytes.Buffer
+		if err := session.Start("hi", "", nil, context.Background(), strings.NewReader(""), &DefaultMessageWriter{Writer: &buf}); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "[REDACTED]\n" || session.History.LastAssistantMessage() != "[REDACTED]" {
+			t.Errorf("stream %v: output = %q, history = %q", stream, buf.String(), session.History.LastAssistantMessage())
+		}
+	}
+}
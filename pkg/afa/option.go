@@ -0,0 +1,179 @@
+package afa
+
+type Option struct {
+	Script *ScriptOption `json:"script"`
+	Init   *InitOption   `json:"init"`
+	Chat   *ChatOption   `json:"chat"`
+	Viewer *ViewerOption `json:"viewer"`
+	List   *ListOption   `json:"list"`
+	Serve  *ServeOption  `json:"serve"`
+	Cache  *CacheOption  `json:"cache"`
+	Hooks  *HooksOption  `json:"hooks"`
+	Redact *RedactOption `json:"redact"`
+
+	Encryption *EncryptionOption `json:"encryption"`
+}
+
+type ScriptOption struct {
+	Enabled bool `json:"enabled"`
+}
+
+type InitOption struct {
+	NoInteraction bool `json:"no_interaction"`
+}
+
+type ChatOption struct {
+	Model                string  `json:"model"`
+	SystemPromptTemplate string  `json:"system_prompt_template"`
+	UserPromptTemplate   string  `json:"user_prompt_template"`
+	Schema               string  `json:"schema"`
+	RunsOn               string  `json:"runs_on"`
+	Interactive          bool    `json:"interactive"`
+	Stream               bool    `json:"stream"`
+	WithHistory          bool    `json:"with_history"`
+	DryRun               bool    `json:"dry_run"`
+	MockRun              bool    `json:"mock_run"`
+	Quote                bool    `json:"quote"`
+	Save                 bool    `json:"save"`
+	RepairRetries        int     `json:"repair_retries"`
+	Extract              string  `json:"extract"`
+	Code                 string  `json:"code"`
+	CodeWrite            bool    `json:"code_write"`
+	CodeOverwrite        bool    `json:"code_overwrite"`
+	Trace                string  `json:"trace"`
+	Record               string  `json:"record"`
+	Replay               string  `json:"replay"`
+	ReplaySpeed          float64 `json:"replay_speed"`
+}
+
+type ListOption struct {
+	Count         int  `json:"count"`
+	OrderByModify bool `json:"order_by_modify"`
+}
+
+type ServeOption struct {
+	Listen string `json:"listen"`
+	// Token is the bearer token of the API. A random token is generated if it is empty.
+	Token string `json:"token"`
+}
+
+type CacheOption struct {
+	Enabled   bool   `json:"enabled"`
+	TTL       string `json:"ttl"`
+	MaxSizeMB int    `json:"max_size_mb"`
+}
+
+type RedactOption struct {
+	Mode    string `json:"mode"`
+	Entropy bool   `json:"entropy"`
+	// Patterns are the regular expressions of secrets by name.
+	Patterns map[string]string `json:"patterns"`
+}
+
+// EncryptionOption encrypts the secret and the sessions. The key is derived from the key file, or the passphrase without it.
+type EncryptionOption struct {
+	Enabled bool   `json:"enabled"`
+	KeyFile string `json:"key_file"`
+}
+
+// HooksOption has the commands of each stage. A command is the program and its arguments.
+type HooksOption struct {
+	SessionStart [][]string `json:"session_start"`
+	PreRequest   [][]string `json:"pre_request"`
+	PostResponse [][]string `json:"post_response"`
+	SessionSave  [][]string `json:"session_save"`
+}
+
+func (o *HooksOption) Commands(stage HookStage) [][]string {
+	switch stage {
+	case HookSessionStart:
+		return o.SessionStart
+	case HookPreRequest:
+		return o.PreRequest
+	case HookPostResponse:
+		return o.PostResponse
+	case HookSessionSave:
+		return o.SessionSave
+	}
+	return nil
+}
+
+type ViewerOption struct {
+	Enabled   bool     `json:"enabled"`
+	Mode      string   `json:"mode"`
+	Command   []string `json:"command"`
+	WebListen string   `json:"web_listen"`
+}
+
+const (
+	ViewerModeCommand = "command"
+	ViewerModeWeb     = "web"
+)
+
+func NewOption() *Option {
+	return &Option{
+		Init: &InitOption{
+			NoInteraction: false,
+		},
+		Script: &ScriptOption{
+			Enabled: false,
+		},
+		Chat: &ChatOption{
+			Model:                "gpt-4o-mini",
+			SystemPromptTemplate: "default",
+			UserPromptTemplate:   "default",
+			Schema:               "",
+			RunsOn:               "",
+			Interactive:          false,
+			Stream:               false,
+			WithHistory:          false,
+			DryRun:               false,
+			MockRun:              false,
+			Quote:                false,
+			Save:                 true,
+			RepairRetries:        0,
+			Extract:              "",
+			Code:                 "",
+			CodeWrite:            false,
+			CodeOverwrite:        false,
+			ReplaySpeed:          1,
+		},
+		Viewer: &ViewerOption{
+			Enabled:   false,
+			Mode:      ViewerModeCommand,
+			Command:   []string{"afa-tui", "-a"},
+			WebListen: "127.0.0.1:0",
+		},
+		List: &ListOption{
+			Count:         10,
+			OrderByModify: false,
+		},
+		Serve: &ServeOption{
+			Listen: "127.0.0.1:8080",
+			Token:  "",
+		},
+		Cache: &CacheOption{
+			Enabled:   false,
+			TTL:       "24h",
+			MaxSizeMB: 100,
+		},
+		Hooks: &HooksOption{},
+		Redact: &RedactOption{
+			Mode:     RedactModeWarn,
+			Entropy:  false,
+			Patterns: map[string]string{},
+		},
+		Encryption: &EncryptionOption{
+			Enabled: false,
+			KeyFile: "",
+		},
+	}
+}
+
+func (o *Option) SetScriptOptions() {
+	o.Chat.Interactive = false
+	o.Chat.WithHistory = false
+	o.Chat.Stream = false
+	o.Chat.Save = false
+	o.Viewer.Enabled = false
+}
@@ -0,0 +1,308 @@
+package afa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/monochromegane/afa/internal/jsonpath"
+	"github.com/monochromegane/afa/internal/markdown"
+)
+
+type ResponsePrinter interface {
+	PrintChunk(chunk string) error
+	EndStream() error
+	PrintMessage(message string) error
+}
+
+// discardPrinter receives the chunks of a response that is printed as a whole later.
+type discardPrinter struct{}
+
+func (discardPrinter) PrintChunk(chunk string) error     { return nil }
+func (discardPrinter) EndStream() error                  { return nil }
+func (discardPrinter) PrintMessage(message string) error { return nil }
+
+type TextPrinter struct {
+	w        io.Writer
+	verb     string
+	streamed bool
+}
+
+func NewTextPrinter(w io.Writer, verb string) *TextPrinter {
+	return &TextPrinter{w: w, verb: verb}
+}
+
+func (p *TextPrinter) PrintChunk(chunk string) error {
+	_, err := fmt.Fprintf(p.w, p.verb, chunk)
+	return err
+}
+
+func (p *TextPrinter) EndStream() error {
+	p.streamed = true
+	_, err := fmt.Fprintln(p.w)
+	return err
+}
+
+func (p *TextPrinter) PrintMessage(message string) error {
+	if p.streamed {
+		return nil
+	}
+	_, err := fmt.Fprintf(p.w, p.verb, message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(p.w)
+	return err
+}
+
+type ExtractPrinter struct {
+	w    io.Writer
+	verb string
+	path jsonpath.Path
+}
+
+func NewExtractPrinter(w io.Writer, verb string, path jsonpath.Path) *ExtractPrinter {
+	return &ExtractPrinter{w: w, verb: verb, path: path}
+}
+
+func (p *ExtractPrinter) PrintChunk(chunk string) error {
+	return nil
+}
+
+func (p *ExtractPrinter) EndStream() error {
+	return nil
+}
+
+func (p *ExtractPrinter) PrintMessage(message string) error {
+	var doc any
+	if err := json.Unmarshal([]byte(message), &doc); err != nil {
+		return fmt.Errorf("Failed to extract %s from the response. %v", p.path, err)
+	}
+	value, err := p.path.Lookup(doc)
+	if err != nil {
+		return err
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	}
+	_, err = fmt.Fprintf(p.w, p.verb, text)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(p.w)
+	return err
+}
+
+type CodePrinter struct {
+	w        io.Writer
+	verb     string
+	selector *markdown.Selector
+	write    bool
+	// Overwrite allows writing code blocks to existing files.
+	Overwrite bool
+	parser    *markdown.CodeBlockParser
+	streamed  bool
+
+	matched bool
+	skip    bool
+	file    *strings.Builder
+	files   []*codeFile
+	output  strings.Builder
+	newline bool
+}
+
+// codeFile is a code block to write, which is written when the whole response is printed.
+type codeFile struct {
+	name    string
+	content string
+}
+
+func NewCodePrinter(w io.Writer, verb string, selector *markdown.Selector, write bool) *CodePrinter {
+	p := &CodePrinter{
+		w:        w,
+		verb:     verb,
+		selector: selector,
+		write:    write,
+		newline:  true,
+	}
+	p.parser = &markdown.CodeBlockParser{
+		OnStart: p.onStart,
+		OnLine:  p.onLine,
+		OnEnd:   p.onEnd,
+	}
+	return p
+}
+
+func (p *CodePrinter) PrintChunk(chunk string) error {
+	return p.parser.Write(chunk)
+}
+
+func (p *CodePrinter) EndStream() error {
+	p.streamed = true
+	return p.parser.Close()
+}
+
+func (p *CodePrinter) PrintMessage(message string) error {
+	if !p.streamed {
+		if err := p.parser.Write(message); err != nil {
+			return err
+		}
+		if err := p.parser.Close(); err != nil {
+			return err
+		}
+	}
+	if !p.matched {
+		return fmt.Errorf("No code block matched in the response.")
+	}
+	if err := p.writeFiles(); err != nil {
+		return err
+	}
+	if p.quoted() {
+		if _, err := fmt.Fprintf(p.w, p.verb, p.output.String()); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(p.w)
+		return err
+	}
+	if !p.newline {
+		_, err := fmt.Fprintln(p.w)
+		return err
+	}
+	return nil
+}
+
+func (p *CodePrinter) onStart(block *markdown.CodeBlock) error {
+	p.skip = !p.selector.Match(block)
+	if p.skip {
+		return nil
+	}
+	p.matched = true
+	if p.write && block.FileName != "" {
+		if !filepath.IsLocal(block.FileName) {
+			return fmt.Errorf("%s: refusing to write a code block outside of the current directory", block.FileName)
+		}
+		for _, element := range strings.Split(filepath.ToSlash(block.FileName), "/") {
+			if strings.EqualFold(element, ".git") {
+				return fmt.Errorf("%s: refusing to write a code block into the .git directory", block.FileName)
+			}
+		}
+		p.file = &strings.Builder{}
+	}
+	return nil
+}
+
+func (p *CodePrinter) onLine(block *markdown.CodeBlock, line string) error {
+	switch {
+	case p.skip:
+		return nil
+	case p.file != nil:
+		p.file.WriteString(line)
+		return nil
+	case p.quoted():
+		p.output.WriteString(line)
+		return nil
+	}
+	p.newline = strings.HasSuffix(line, "\n")
+	_, err := io.WriteString(p.w, line)
+	return err
+}
+
+func (p *CodePrinter) onEnd(block *markdown.CodeBlock) error {
+	if p.file == nil {
+		return nil
+	}
+	p.files = append(p.files, &codeFile{name: block.FileName, content: p.file.String()})
+	p.file = nil
+	return nil
+}
+
+// writeFiles writes the code blocks after the response has been accepted,
+// and refuses to overwrite existing files unless Overwrite is set.
+func (p *CodePrinter) writeFiles() error {
+	if len(p.files) == 0 {
+		return nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(wd)
+	if err != nil {
+		return err
+	}
+	for _, file := range p.files {
+		if _, err := os.Lstat(file.name); err == nil && !p.Overwrite {
+			return fmt.Errorf("%s: refusing to overwrite the existing file. Use -code-overwrite to overwrite it.", file.name)
+		}
+		if err := checkCodeFileLinks(root, file.name); err != nil {
+			return err
+		}
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !p.Overwrite {
+		flag |= os.O_EXCL
+	}
+	for _, file := range p.files {
+		if dir := filepath.Dir(file.name); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		f, err := os.OpenFile(file.name, flag, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.content); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	p.files = nil
+	return nil
+}
+
+// checkCodeFileLinks refuses a path that leads outside of the root or into .git through symbolic links.
+// The nearest existing one of the path and its parents is resolved, since the others are created.
+func checkCodeFileLinks(root, name string) error {
+	existing := name
+	for existing != "." {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+		return fmt.Errorf("%s: refusing to write a code block outside of the current directory", name)
+	}
+	for _, element := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.EqualFold(element, ".git") {
+			return fmt.Errorf("%s: refusing to write a code block into the .git directory", name)
+		}
+	}
+	return nil
+}
+
+func (p *CodePrinter) quoted() bool {
+	return p.verb != "%s"
+}
@@ -0,0 +1,168 @@
+package afa
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/monochromegane/afa/internal/jsonpath"
+	"github.com/monochromegane/afa/internal/markdown"
+)
+
+func TestExtractPrinter(t *testing.T) {
+	message := `{"suggested_command":"echo \"hi\"","files":["a.go"]}`
+	tests := []struct {
+		expr   string
+		verb   string
+		output string
+		found  bool
+	}{
+		{expr: ".suggested_command", verb: "%s", output: "echo \"hi\"\n", found: true},
+		{expr: ".suggested_command", verb: "%q", output: "\"echo \\\"hi\\\"\"\n", found: true},
+		{expr: ".files", verb: "%s", output: "[\"a.go\"]\n", found: true},
+		{expr: ".missing", verb: "%s", found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr+tt.verb, func(t *testing.T) {
+			path, err := jsonpath.Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			printer := NewExtractPrinter(&buf, tt.verb, path)
+			printer.PrintChunk(message)
+			printer.EndStream()
+			err = printer.PrintMessage(message)
+
+			var nerr *jsonpath.NotFoundError
+			if !tt.found {
+				if !errors.As(err, &nerr) {
+					t.Errorf("PrintMessage should return NotFoundError, but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PrintMessage should not return error, but got %v", err)
+			}
+			if buf.String() != tt.output {
+				t.Errorf("PrintMessage should output %q, but got %q", tt.output, buf.String())
+			}
+		})
+	}
+}
+
+func TestCodePrinter(t *testing.T) {
+	message := "Use this.\n```go\nfmt.Println(1)\n```\nOr this.\n```sh\necho 1\n```"
+	tests := []struct {
+		selector string
+		verb     string
+		output   string
+	}{
+		{selector: "0", verb: "%s", output: "fmt.Println(1)\n"},
+		{selector: "sh", verb: "%s", output: "echo 1\n"},
+		{selector: "all", verb: "%s", output: "fmt.Println(1)\necho 1\n"},
+		{selector: "all", verb: "%q", output: "\"fmt.Println(1)\\necho 1\\n\"\n"},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			var buf bytes.Buffer
+			printer := NewCodePrinter(&buf, tt.verb, markdown.ParseSelector(tt.selector), false)
+			if stream {
+				for _, r := range message {
+					printer.PrintChunk(string(r))
+				}
+				printer.EndStream()
+			}
+			if err := printer.PrintMessage(message); err != nil {
+				t.Fatalf("PrintMessage should not return error, but got %v", err)
+			}
+			if buf.String() != tt.output {
+				t.Errorf("CodePrinter(%s, %s, stream=%v) should output %q, but got %q", tt.selector, tt.verb, stream, tt.output, buf.String())
+			}
+		}
+	}
+
+	printer := NewCodePrinter(io.Discard, "%s", markdown.ParseSelector("python"), false)
+	if err := printer.PrintMessage(message); err == nil {
+		t.Errorf("PrintMessage should return error when no code block matched")
+	}
+}
+
+func TestCodePrinterWritesFiles(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	write := func(message string, overwrite bool) error {
+		printer := NewCodePrinter(io.Discard, "%s", markdown.ParseSelector("all"), true)
+		printer.Overwrite = overwrite
+		if err := printer.PrintChunk(message); err != nil {
+			return err
+		}
+		if err := printer.EndStream(); err != nil {
+			return err
+		}
+		if _, err := os.Stat("cmd/main.go"); err == nil {
+			t.Errorf("Code blocks should not be written before the whole response is printed")
+		}
+		return printer.PrintMessage(message)
+	}
+
+	if err := write("```go cmd/main.go\npackage main\n```\n", false); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile("cmd/main.go"); err != nil || string(data) != "package main\n" {
+		t.Errorf("cmd/main.go = %q, %v", data, err)
+	}
+	os.Rename("cmd/main.go", "main.go")
+	if err := write("```go main.go\npackage other\n```\n", false); err == nil {
+		t.Errorf("PrintMessage should refuse to overwrite an existing file")
+	}
+	if err := write("```go main.go\npackage other\n```\n", true); err != nil {
+		t.Errorf("PrintMessage should overwrite with Overwrite: %v", err)
+	}
+	for _, name := range []string{".git/hooks/pre-commit", "sub/.GIT/config", "../outside.go"} {
+		if err := write("```sh "+name+"\necho\n```\n", false); err == nil {
+			t.Errorf("PrintMessage should refuse to write %s", name)
+		}
+	}
+
+	// Symbolic links are resolved, so that they do not lead outside of the current directory or into .git.
+	outside := t.TempDir()
+	outsideFile := filepath.Join(t.TempDir(), "x.go")
+	if err := os.WriteFile(outsideFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(".git", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"outside": outside, "git": ".git", "outside.go": outsideFile} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"outside/x.go", "outside/new/x.go", "git/config", "outside.go"} {
+		if err := write("```go "+name+"\npackage main\n```\n", true); err == nil {
+			t.Errorf("PrintMessage should refuse to write %s through the symbolic link", name)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("PrintMessage should not write outside of the current directory, but wrote %v", entries)
+	}
+	if data, err := os.ReadFile(outsideFile); err != nil || len(data) != 0 {
+		t.Errorf("PrintMessage should not overwrite the file outside of the current directory")
+	}
+	if _, err := os.Stat(".git/config"); err == nil {
+		t.Errorf("PrintMessage should not write into .git through the symbolic link")
+	}
+}
@@ -0,0 +1,99 @@
+package afa
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SessionStartupGrace is the time that a process may take from locking a session to listening on its socket.
+const SessionStartupGrace = 5 * time.Second
+
+// SessionInUseError is returned when another live process serves the session.
+type SessionInUseError struct {
+	Name string
+	PID  int
+}
+
+func (e *SessionInUseError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("Session %s is already running in another afa process. Attach with \"afa attach -l %s\".", e.Name, e.Name)
+	}
+	return fmt.Sprintf("Session %s is already running in another afa process (pid %d). Attach with \"afa attach -l %s\".", e.Name, e.PID, e.Name)
+}
+
+// SessionProcess describes the process that serves a session on its socket.
+type SessionProcess struct {
+	PID       int       `json:"pid"`
+	Socket    string    `json:"socket"`
+	StartedAt time.Time `json:"started_at"`
+	Detached  bool      `json:"detached"`
+}
+
+func NewSessionProcess(socketPath string, detached bool) *SessionProcess {
+	return &SessionProcess{
+		PID:       os.Getpid(),
+		Socket:    socketPath,
+		StartedAt: time.Now(),
+		Detached:  detached,
+	}
+}
+
+// Alive reports whether the session socket accepts connections,
+// or whether the process exists when it runs the session without a socket.
+func (p *SessionProcess) Alive() bool {
+	if p.Socket == "" {
+		return processExists(p.PID)
+	}
+	conn, err := net.DialTimeout("unix", p.Socket, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Starting reports whether the process has locked the session recently and may not listen yet.
+func (p *SessionProcess) Starting() bool {
+	return time.Since(p.StartedAt) < SessionStartupGrace && processExists(p.PID)
+}
+
+// Running reports whether the process still runs the session.
+// A process that has gone may have left its PID to another program, which must not be signaled.
+func (p *SessionProcess) Running() bool {
+	return processExists(p.PID) && (p.Alive() || p.Starting()) && isAfaProcess(p.PID)
+}
+
+// isAfaProcess reports whether the process runs the same executable as the current one.
+// It is assumed without procfs, where the executable can not be checked.
+func isAfaProcess(pid int) bool {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return !errors.Is(err, os.ErrNotExist) || !procfsExists()
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return true
+	}
+	return filepath.Base(strings.TrimSuffix(exe, " (deleted)")) == filepath.Base(self)
+}
+
+func procfsExists() bool {
+	_, err := os.Stat("/proc/self/exe")
+	return err == nil
+}
+
+func processExists(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// Signal 0 checks the existence of the process on Unix. It is not supported on Windows,
+	// where finding the process has already checked it.
+	return !errors.Is(process.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
@@ -0,0 +1,110 @@
+package afa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"text/template"
+)
+
+type PromptContext struct {
+	Message      string
+	MessageStdin string
+	Files        []*PromptFile
+	Context      map[string]string
+}
+
+type PromptFile struct {
+	Name    string
+	Content string
+}
+
+func NewPrompt(fsys fs.FS, promptTemplatePath, ctxString, message, messageStdin string, files []string) (string, error) {
+	return newPrompt(fsys, promptTemplatePath, ctxString, message, messageStdin, files, os.ReadFile)
+}
+
+// newPrompt reads the files with readFile.
+func newPrompt(fsys fs.FS, promptTemplatePath, ctxString, message, messageStdin string, files []string, readFile func(string) ([]byte, error)) (string, error) {
+	tmpl, err := loadPromptTemplate(fsys, promptTemplatePath)
+	if err != nil {
+		return "", err
+	}
+
+	promptContext, err := newPromptContext(ctxString, message, messageStdin, files, readFile)
+	if err != nil {
+		return "", err
+	}
+
+	var prompt bytes.Buffer
+	err = tmpl.Execute(&prompt, promptContext)
+	if err != nil {
+		return "", err
+	}
+	return prompt.String(), nil
+}
+
+func ValidatePromptTemplate(fsys fs.FS, promptTemplatePath string) error {
+	tmpl, err := loadPromptTemplate(fsys, promptTemplatePath)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(io.Discard, samplePromptContext())
+}
+
+func loadPromptTemplate(fsys fs.FS, promptTemplatePath string) (*template.Template, error) {
+	promptTemplate, err := fs.ReadFile(fsys, promptTemplatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return template.New("prompt").Parse(string(promptTemplate))
+}
+
+func newPromptContext(ctxString, message, messageStdin string, files []string, readFile func(string) ([]byte, error)) (*PromptContext, error) {
+	if ctxString == "" {
+		ctxString = "{}"
+	}
+	var ctx map[string]string
+	if err := json.Unmarshal([]byte(ctxString), &ctx); err != nil {
+		return nil, err
+	}
+
+	var fileData []*PromptFile
+	for _, file := range files {
+		content, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		fileData = append(fileData, &PromptFile{
+			Name:    file,
+			Content: string(content),
+		})
+	}
+
+	return &PromptContext{
+		Message:      message,
+		MessageStdin: messageStdin,
+		Files:        fileData,
+		Context:      ctx,
+	}, nil
+}
+
+func samplePromptContext() *PromptContext {
+	files := []*PromptFile{}
+	for i := 1; i <= 3; i++ {
+		files = append(files, &PromptFile{
+			Name:    fmt.Sprintf("sample%d.txt", i),
+			Content: fmt.Sprintf("Content of sample file %d.\n", i),
+		})
+	}
+	return &PromptContext{
+		Message:      "Sample message.",
+		MessageStdin: "Sample standard input.\n",
+		Files:        files,
+		Context:      map[string]string{},
+	}
+}
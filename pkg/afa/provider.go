@@ -0,0 +1,148 @@
+package afa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/monochromegane/afa/internal/jsonschema"
+	"github.com/monochromegane/afa/internal/llm"
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+type (
+	Request     = payload.Request
+	Message     = payload.Message
+	Response    = payload.Response
+	Usage       = payload.Usage
+	JsonSchema  = payload.JsonSchema
+	SchemaError = jsonschema.Error
+)
+
+// Client is the interface of a provider of chat completions.
+type Client = llm.LLMClient
+
+// ProviderFactory returns the client of a provider for the model. The model does not have the prefix of the provider.
+type ProviderFactory func(model string) (Client, error)
+
+const DefaultProvider = "openai"
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderFactory{
+		DefaultProvider: func(model string) (Client, error) {
+			return llm.GetLLMClient(model), nil
+		},
+	}
+)
+
+// RegisterProvider makes the provider available to models prefixed by "name/".
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = factory
+}
+
+// Providers returns the sorted names of the registered providers.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProviderPluginPrefix is the prefix of the executables of provider plugins. (e.g. afa-provider-NAME)
+const ProviderPluginPrefix = "afa-provider-"
+
+// PluginProvider returns the provider that runs the executable of a plugin.
+func PluginProvider(path string) ProviderFactory {
+	return func(model string) (Client, error) {
+		return llm.NewPluginClient(path), nil
+	}
+}
+
+// RegisterProviderPlugins registers the executables of plugins in the directory. Providers that are already registered are kept.
+func RegisterProviderPlugins(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), ProviderPluginPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || info.Mode()&0o111 == 0 {
+			continue
+		}
+		if _, ok := providers[name]; !ok {
+			providers[name] = PluginProvider(path)
+		}
+	}
+	return nil
+}
+
+// SplitModel splits "provider/model" into the provider and the model. A model without the prefix belongs to the default provider.
+func SplitModel(model string) (string, string) {
+	if provider, name, ok := strings.Cut(model, "/"); ok && provider != "" {
+		return provider, name
+	}
+	return DefaultProvider, model
+}
+
+// NewClient returns the client of the provider that the model is routed to.
+func NewClient(model string) (Client, error) {
+	provider, name := SplitModel(model)
+	providersMu.RLock()
+	factory, ok := providers[provider]
+	providersMu.RUnlock()
+	if !ok {
+		// An unregistered provider is looked up as a plugin on PATH.
+		path, err := exec.LookPath(ProviderPluginPrefix + provider)
+		if err != nil {
+			return nil, fmt.Errorf("Provider %q is not registered, and %s%s is not found. Registered providers: %s", provider, ProviderPluginPrefix, provider, strings.Join(Providers(), ", "))
+		}
+		factory = PluginProvider(path)
+	}
+	client, err := factory(name)
+	if err != nil || name == model {
+		return client, err
+	}
+	return &modelClient{client: client, model: name}, nil
+}
+
+// modelClient sends requests with the model that does not have the prefix of the provider.
+type modelClient struct {
+	client Client
+	model  string
+}
+
+func (c *modelClient) ChatCompletion(request *Request, ctx context.Context) (*Response, error) {
+	r := *request
+	r.Model = c.model
+	return c.client.ChatCompletion(&r, ctx)
+}
+
+func (c *modelClient) ChatCompletionStream(request *Request, ctx context.Context, onData func(*Response) error) error {
+	r := *request
+	r.Model = c.model
+	return c.client.ChatCompletionStream(&r, ctx, onData)
+}
@@ -0,0 +1,74 @@
+package afa
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitModel(t *testing.T) {
+	tests := []struct {
+		model    string
+		provider string
+		name     string
+	}{
+		{model: "gpt-4o-mini", provider: "openai", name: "gpt-4o-mini"},
+		{model: "gateway/model-x", provider: "gateway", name: "model-x"},
+		{model: "gateway/org/model-x", provider: "gateway", name: "org/model-x"},
+		{model: "/model-x", provider: "openai", name: "/model-x"},
+	}
+	for _, tt := range tests {
+		provider, name := SplitModel(tt.model)
+		if provider != tt.provider || name != tt.name {
+			t.Errorf("SplitModel(%q) = %q, %q, want %q, %q", tt.model, provider, name, tt.provider, tt.name)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	stub := &stubClient{responses: []string{"Hello"}}
+	RegisterProvider("stub", func(model string) (Client, error) {
+		if model != "model-x" {
+			t.Errorf("factory model = %q, want %q", model, "model-x")
+		}
+		return stub, nil
+	})
+
+	client, err := NewClient("stub/model-x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &Request{Model: "stub/model-x"}
+	if _, err := client.ChatCompletion(request, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if request.Model != "stub/model-x" {
+		t.Errorf("request.Model = %q, it should not be changed", request.Model)
+	}
+
+	if _, err := NewClient("unknown/model-x"); err == nil {
+		t.Errorf("NewClient should return error for an unregistered provider")
+	}
+}
+
+func TestRegisterProviderPlugins(t *testing.T) {
+	dir := t.TempDir()
+	for name, perm := range map[string]os.FileMode{"afa-provider-plugin": 0o755, "afa-provider-noexec": 0o644, "other": 0o755} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RegisterProviderPlugins(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterProviderPlugins(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("RegisterProviderPlugins should ignore a missing directory, but got %v", err)
+	}
+
+	registered := strings.Join(Providers(), ",")
+	if !strings.Contains(registered, "plugin") || strings.Contains(registered, "noexec") || strings.Contains(registered, "other") {
+		t.Errorf("Providers() = %s", registered)
+	}
+}
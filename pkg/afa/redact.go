@@ -0,0 +1,80 @@
+package afa
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/monochromegane/afa/internal/redact"
+)
+
+const (
+	RedactModeOff    = "off"
+	RedactModeWarn   = "warn"
+	RedactModeRedact = "redact"
+	RedactModeBlock  = "block"
+)
+
+// SecretFinding is a credential found in a prompt.
+type SecretFinding = redact.Finding
+
+// SecretsFoundError is returned when secrets are found in block mode.
+type SecretsFoundError struct {
+	Prompt   string
+	Findings []*SecretFinding
+}
+
+func (e *SecretsFoundError) Error() string {
+	return fmt.Sprintf("%d secret(s) found in the %s. Remove them, or mask them in the redact mode.", len(e.Findings), e.Prompt)
+}
+
+// Redactor scans prompts for secrets before they are sent, and reports them.
+type Redactor struct {
+	Mode    string
+	Scanner *redact.Scanner
+	Report  io.Writer
+}
+
+func NewRedactor(option *RedactOption, report io.Writer) (*Redactor, error) {
+	if option == nil {
+		return nil, nil
+	}
+	switch option.Mode {
+	case RedactModeOff, RedactModeWarn, RedactModeRedact, RedactModeBlock:
+	default:
+		return nil, fmt.Errorf("Unknown redact mode %q. (%s, %s, %s or %s)", option.Mode, RedactModeOff, RedactModeWarn, RedactModeRedact, RedactModeBlock)
+	}
+	minEntropy := 0.0
+	if option.Entropy {
+		minEntropy = redact.DefaultMinEntropy
+	}
+	scanner, err := redact.NewScanner(option.Patterns, minEntropy)
+	if err != nil {
+		return nil, err
+	}
+	return &Redactor{Mode: option.Mode, Scanner: scanner, Report: report}, nil
+}
+
+// Apply returns the prompt to send. The prompt is the name in the report. (e.g. "user prompt")
+func (r *Redactor) Apply(prompt, text string) (string, error) {
+	if r == nil || r.Mode == RedactModeOff {
+		return text, nil
+	}
+	findings := r.Scanner.Scan(text)
+	if len(findings) == 0 {
+		return text, nil
+	}
+
+	action := map[string]string{RedactModeWarn: "sent as is", RedactModeRedact: "redacted", RedactModeBlock: "blocked"}[r.Mode]
+	fmt.Fprintf(r.Report, "afa: %d secret(s) found in the %s (%s):\n", len(findings), prompt, action)
+	for _, finding := range findings {
+		fmt.Fprintf(r.Report, "  line %d: %s %s\n", finding.Line, finding.Rule, finding.Masked())
+	}
+
+	switch r.Mode {
+	case RedactModeRedact:
+		return redact.Redact(text, findings), nil
+	case RedactModeBlock:
+		return "", &SecretsFoundError{Prompt: prompt, Findings: findings}
+	}
+	return text, nil
+}
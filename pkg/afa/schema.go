@@ -0,0 +1,105 @@
+package afa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ValidateStrictSchema checks the schema against the rules of OpenAI's strict mode for structured outputs.
+func ValidateStrictSchema(data []byte) error {
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return err
+	}
+
+	errs := []error{}
+	if !hasType(schema, "object") {
+		errs = append(errs, fmt.Errorf("#: root schema must be of type \"object\""))
+	}
+	errs = append(errs, validateStrictSchema("#", schema)...)
+	return errors.Join(errs...)
+}
+
+func validateStrictSchema(pointer string, schema map[string]any) []error {
+	errs := []error{}
+
+	properties, _ := schema["properties"].(map[string]any)
+	if hasType(schema, "object") || properties != nil {
+		if additional, ok := schema["additionalProperties"].(bool); !ok || additional {
+			errs = append(errs, fmt.Errorf("%s: \"additionalProperties\" must be false", pointer))
+		}
+
+		required := map[string]bool{}
+		if values, ok := schema["required"].([]any); ok {
+			for _, value := range values {
+				if name, ok := value.(string); ok {
+					required[name] = true
+				}
+			}
+		}
+		for _, name := range sortedKeys(properties) {
+			if !required[name] {
+				errs = append(errs, fmt.Errorf("%s: property %q must be listed in \"required\"", pointer, name))
+			}
+		}
+		for _, name := range sortedKeys(required) {
+			if _, ok := properties[name]; !ok {
+				errs = append(errs, fmt.Errorf("%s: required property %q is not defined in \"properties\"", pointer, name))
+			}
+		}
+		for _, name := range sortedKeys(properties) {
+			if property, ok := properties[name].(map[string]any); ok {
+				errs = append(errs, validateStrictSchema(fmt.Sprintf("%s/properties/%s", pointer, name), property)...)
+			}
+		}
+	}
+
+	if items, ok := schema["items"].(map[string]any); ok {
+		errs = append(errs, validateStrictSchema(fmt.Sprintf("%s/items", pointer), items)...)
+	}
+	for _, keyword := range []string{"anyOf", "allOf", "oneOf"} {
+		if subschemas, ok := schema[keyword].([]any); ok {
+			for i, subschema := range subschemas {
+				if subschema, ok := subschema.(map[string]any); ok {
+					errs = append(errs, validateStrictSchema(fmt.Sprintf("%s/%s/%d", pointer, keyword, i), subschema)...)
+				}
+			}
+		}
+	}
+	for _, keyword := range []string{"$defs", "definitions"} {
+		if defs, ok := schema[keyword].(map[string]any); ok {
+			for _, name := range sortedKeys(defs) {
+				if def, ok := defs[name].(map[string]any); ok {
+					errs = append(errs, validateStrictSchema(fmt.Sprintf("%s/%s/%s", pointer, keyword, name), def)...)
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+func hasType(schema map[string]any, name string) bool {
+	switch t := schema["type"].(type) {
+	case string:
+		return t == name
+	case []any:
+		for _, v := range t {
+			if v == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,49 @@
+package afa
+
+import "testing"
+
+func TestValidateStrictSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		valid  bool
+	}{
+		{
+			name:   "strict object",
+			schema: `{"type":"object","properties":{"a":{"type":"string"}},"additionalProperties":false,"required":["a"]}`,
+			valid:  true,
+		},
+		{
+			name:   "root is not an object",
+			schema: `{"type":"string"}`,
+			valid:  false,
+		},
+		{
+			name:   "additionalProperties is missing",
+			schema: `{"type":"object","properties":{"a":{"type":"string"}},"required":["a"]}`,
+			valid:  false,
+		},
+		{
+			name:   "property is not required",
+			schema: `{"type":"object","properties":{"a":{"type":"string"}},"additionalProperties":false,"required":[]}`,
+			valid:  false,
+		},
+		{
+			name:   "nested object in array is not strict",
+			schema: `{"type":"object","properties":{"a":{"type":"array","items":{"type":"object","properties":{"b":{"type":"string"}},"required":["b"]}}},"additionalProperties":false,"required":["a"]}`,
+			valid:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateStrictSchema([]byte(tt.schema))
+			if tt.valid && err != nil {
+				t.Errorf("ValidateStrictSchema should not return error, but got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("ValidateStrictSchema should return error")
+			}
+		})
+	}
+}
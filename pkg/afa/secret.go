@@ -0,0 +1,68 @@
+package afa
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// OpenAIAPIKeyEnv is the environment variable of the API key, which takes precedence over the secret file.
+const OpenAIAPIKeyEnv = "OPENAI_API_KEY"
+
+type Secret struct {
+	OpenAI *OpenAISecret `json:"openai"`
+}
+
+type OpenAISecret struct {
+	ApiKey string `json:"api_key"`
+	// CredentialCommand prints the API key, such as a password manager. (e.g. ["pass", "show", "openai"])
+	CredentialCommand []string `json:"credential_command,omitempty"`
+}
+
+func NewSecret(openai_api_key string) *Secret {
+	return &Secret{
+		OpenAI: &OpenAISecret{
+			ApiKey: openai_api_key,
+		},
+	}
+}
+
+// Resolve sets the API key in order of precedence: the environment variable, the credential command and the secret file.
+func (s *Secret) Resolve() error {
+	if s.OpenAI == nil {
+		s.OpenAI = &OpenAISecret{}
+	}
+	if apiKey := os.Getenv(OpenAIAPIKeyEnv); apiKey != "" {
+		s.OpenAI.ApiKey = apiKey
+		return nil
+	}
+	if len(s.OpenAI.CredentialCommand) == 0 {
+		return nil
+	}
+	apiKey, err := runCredentialCommand(s.OpenAI.CredentialCommand)
+	if err != nil {
+		return err
+	}
+	s.OpenAI.ApiKey = apiKey
+	return nil
+}
+
+func runCredentialCommand(command []string) (string, error) {
+	name := strings.Join(command, " ")
+	cmd := exec.Command(command[0], command[1:]...)
+	// The standard input is left for the message. Password managers ask for the passphrase on the terminal.
+	cmd.Stderr = os.Stderr
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Failed to get the API key from the credential command %q. %v", name, err)
+	}
+	// Some password managers print other fields after the first line.
+	apiKey, _, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+	if apiKey = strings.TrimSpace(apiKey); apiKey == "" {
+		return "", fmt.Errorf("The credential command %q printed no API key.", name)
+	}
+	return apiKey, nil
+}
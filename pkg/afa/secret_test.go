@@ -0,0 +1,66 @@
+package afa
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestSecretResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		command []string
+		want    string
+		err     bool
+	}{
+		{name: "file", want: "file-key"},
+		{name: "credential command", command: []string{"printf", "command-key\nusername: afa\n"}, want: "command-key"},
+		{name: "environment variable", env: "env-key", command: []string{"false"}, want: "env-key"},
+		{name: "failed command", command: []string{"false"}, err: true},
+		{name: "empty output", command: []string{"true"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(OpenAIAPIKeyEnv, tt.env)
+			secret := NewSecret("file-key")
+			secret.OpenAI.CredentialCommand = tt.command
+			err := secret.Resolve()
+			if tt.err {
+				if err == nil {
+					t.Errorf("Resolve should return error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if secret.OpenAI.ApiKey != tt.want {
+				t.Errorf("ApiKey = %q, want %q", secret.OpenAI.ApiKey, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkSpaceWithoutSecretFile(t *testing.T) {
+	workSpace := NewWorkSpaceWithStorage(NewMemoryStorage(), t.TempDir())
+
+	t.Setenv(OpenAIAPIKeyEnv, "")
+	if exists, err := workSpace.SetupIfKeyGiven(); err != nil || exists {
+		t.Errorf("SetupIfKeyGiven() = %v, %v, want false without the API key", exists, err)
+	}
+	if _, err := workSpace.LoadSecret(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadSecret() error = %v, want ErrNotExist", err)
+	}
+
+	t.Setenv(OpenAIAPIKeyEnv, "env-key")
+	if exists, err := workSpace.SetupIfKeyGiven(); err != nil || !exists {
+		t.Fatalf("SetupIfKeyGiven() = %v, %v, want true with the API key", exists, err)
+	}
+	if workSpace.Exists(workSpace.SecretPath()) || !workSpace.Exists(workSpace.OptionPath()) {
+		t.Errorf("SetupIfKeyGiven() should set up the workspace without the secret file")
+	}
+	if secret, err := workSpace.LoadSecret(); err != nil || secret.OpenAI.ApiKey != "env-key" {
+		t.Errorf("LoadSecret() = %v, %v, want the API key of the environment variable", secret, err)
+	}
+}
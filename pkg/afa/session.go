@@ -0,0 +1,407 @@
+// Package afa provides the session engine of afa: prompt templates, histories, JSON schemas, workspaces and the registry of providers.
+package afa
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/monochromegane/afa/internal/cache"
+	"github.com/monochromegane/afa/internal/jsonpath"
+	"github.com/monochromegane/afa/internal/jsonschema"
+	"github.com/monochromegane/afa/internal/markdown"
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+type MessageReader interface {
+	io.Reader
+}
+
+type MessageWriter interface {
+	io.Writer
+	Disconnect() error
+	Prompt() error
+	Error(error) error
+	MessageStart(role string) error
+	MessageEnd(role string) error
+	Usage(*Usage) error
+}
+
+type DefaultMessageWriter struct {
+	io.Writer
+}
+
+func (w *DefaultMessageWriter) Disconnect() error {
+	return nil
+}
+
+func (w *DefaultMessageWriter) Prompt() error {
+	fmt.Fprint(w, "> ")
+	return nil
+}
+
+func (w *DefaultMessageWriter) Error(err error) error {
+	return nil
+}
+
+func (w *DefaultMessageWriter) MessageStart(role string) error {
+	return nil
+}
+
+func (w *DefaultMessageWriter) MessageEnd(role string) error {
+	return nil
+}
+
+func (w *DefaultMessageWriter) Usage(usage *Usage) error {
+	return nil
+}
+
+type Session struct {
+	Secret                   *Secret
+	History                  *History
+	Templates                fs.FS
+	SystemPromptTemplatePath string
+	UserPromptTemplatePath   string
+	Interactive              bool
+	Stream                   bool
+	WithHistory              bool
+	DryRun                   bool
+	MockRun                  bool
+	RepairRetries            int
+	Verb                     string
+	ExtractPath              jsonpath.Path
+	CodeSelector             *markdown.Selector
+	CodeWrite                bool
+	CodeOverwrite            bool
+	Client                   Client
+	Hooks                    *Hooks
+	Redactor                 *Redactor
+	// ReadFile reads the files of the user prompt. It is os.ReadFile if it is nil.
+	ReadFile func(name string) ([]byte, error)
+}
+
+type ResponseValidationError struct {
+	Response string
+	Errors   []*SchemaError
+}
+
+func (e *ResponseValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("Response does not conform to the JSON schema. %s", strings.Join(messages, ", "))
+}
+
+// SessionOptions configures a session. Templates are paths in the templates of the session.
+type SessionOptions struct {
+	SystemPromptTemplate string
+	UserPromptTemplate   string
+	Interactive          bool
+	Stream               bool
+	WithHistory          bool
+	DryRun               bool
+	MockRun              bool
+	Quote                bool
+	RepairRetries        int
+}
+
+func NewSession(secret *Secret, history *History, templates fs.FS, options SessionOptions) (*Session, error) {
+	client, err := NewClient(history.Model)
+	if err != nil {
+		return nil, err
+	}
+	verb := "%s"
+	if options.Quote {
+		verb = "%q"
+	}
+	return &Session{
+		Secret:                   secret,
+		History:                  history,
+		Templates:                templates,
+		SystemPromptTemplatePath: options.SystemPromptTemplate,
+		UserPromptTemplatePath:   options.UserPromptTemplate,
+		Interactive:              options.Interactive,
+		Stream:                   options.Stream,
+		WithHistory:              options.WithHistory,
+		DryRun:                   options.DryRun,
+		MockRun:                  options.MockRun,
+		RepairRetries:            options.RepairRetries,
+		Verb:                     verb,
+		Client:                   client,
+	}, nil
+}
+
+func (s *Session) SetExtractPath(expr string) error {
+	if expr == "" {
+		s.ExtractPath = nil
+		return nil
+	}
+	path, err := jsonpath.Parse(expr)
+	if err != nil {
+		return err
+	}
+	s.ExtractPath = path
+	return nil
+}
+
+func (s *Session) SetCodeSelector(selector string, write bool) error {
+	if selector == "" {
+		s.CodeSelector = nil
+		return nil
+	}
+	if s.ExtractPath != nil {
+		return fmt.Errorf("Code block extraction cannot be used with JSON path extraction.")
+	}
+	s.CodeSelector = markdown.ParseSelector(selector)
+	s.CodeWrite = write
+	return nil
+}
+
+func (s *Session) Start(message, messageStdin string, files []string, ctx context.Context, r MessageReader, w MessageWriter) error {
+	if s.History.IsNewSession() {
+		systemPrompt, err := NewPrompt(s.Templates, s.SystemPromptTemplatePath, "", message, messageStdin, []string{})
+		if err != nil {
+			return err
+		}
+		systemPrompt, err = s.Redactor.Apply("system prompt", systemPrompt)
+		if err != nil {
+			return err
+		}
+		s.History.AddMessage("system", systemPrompt)
+	} else if s.WithHistory {
+		fmt.Fprint(w, s.History.View(false))
+	}
+
+	runWithInput := false
+	if message != "" || messageStdin != "" || len(files) > 0 {
+		readFile := s.ReadFile
+		if readFile == nil {
+			readFile = os.ReadFile
+		}
+		userPrompt, err := newPrompt(s.Templates, s.UserPromptTemplatePath, "", message, messageStdin, files, readFile)
+		if err != nil {
+			return err
+		}
+		if s.DryRun {
+			userPrompt, err = s.Redactor.Apply("user prompt", userPrompt)
+			if err != nil {
+				return err
+			}
+			s.History.AddMessage("user", userPrompt)
+			fmt.Fprint(w, s.History.View(true))
+			// To prevent saving logs in dry-run mode, the last message is removed.
+			s.History.RemoveLastMessage()
+			return nil
+		}
+
+		err = s.chatCompletionAndPrint(ctx, userPrompt, w)
+		if err != nil {
+			return err
+		}
+		runWithInput = true
+	}
+
+	if runWithInput || s.DryRun {
+		return nil
+	}
+
+	w.Prompt()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		userPrompt := scanner.Text()
+		if userPrompt == "" {
+			w.Prompt()
+			continue
+		}
+		if userPrompt == "exit" {
+			break
+		}
+
+		err := s.chatCompletionAndPrint(ctx, userPrompt, w)
+		if err != nil {
+			return err
+		}
+
+		if !s.Interactive {
+			break
+		}
+		w.Prompt()
+	}
+
+	return nil
+}
+
+func (s *Session) chatCompletionAndPrint(ctx context.Context, userPrompt string, w MessageWriter) error {
+	if s.MockRun {
+		return s.printMessage(w, "assistant", s.newResponsePrinter(w), s.History.LastAssistantMessage())
+	}
+
+	ctx = context.WithValue(ctx, "openai-api-key", s.Secret.OpenAI.ApiKey)
+
+	schema, err := s.responseSchema()
+	if err != nil {
+		return err
+	}
+
+	userPrompt, err = s.Redactor.Apply("user prompt", userPrompt)
+	if err != nil {
+		return err
+	}
+	s.History.AddMessage("user", userPrompt)
+
+	streaming := s.StreamsResponses()
+	// Only the response that passes the validation is cached.
+	responseCache := cache.FromContext(ctx)
+	defer responseCache.Discard()
+	for retries := 0; ; retries++ {
+		printer := s.newResponsePrinter(w)
+		chunkPrinter := ResponsePrinter(discardPrinter{})
+		if streaming {
+			chunkPrinter = printer
+			if err := w.MessageStart("assistant"); err != nil {
+				return err
+			}
+		}
+		request, _, err := s.Hooks.Run(ctx, HookPreRequest, s.History.Request, nil)
+		if err != nil {
+			return err
+		}
+		response, err := s.chatCompletion(ctx, request, chunkPrinter)
+		if err != nil {
+			return err
+		}
+		_, response, err = s.Hooks.Run(ctx, HookPostResponse, request, response)
+		if err != nil {
+			return err
+		}
+		role := response.Message.Role
+		message := response.Message.Content
+		s.History.AddMessage(role, message)
+		s.History.AddUsage(response.Usage)
+		if response.Usage != nil {
+			if err := w.Usage(response.Usage); err != nil {
+				return err
+			}
+		}
+
+		err = s.validateResponse(schema, message)
+		if err == nil {
+			// The cache is only an optimization, so failing to store the response does not fail the session.
+			responseCache.Commit()
+			if streaming {
+				if err := printer.PrintMessage(message); err != nil {
+					return err
+				}
+				return w.MessageEnd(role)
+			}
+			return s.printMessage(w, role, printer, message)
+		}
+		if streaming {
+			if err := w.MessageEnd(role); err != nil {
+				return err
+			}
+		}
+		if retries >= s.RepairRetries {
+			return err
+		}
+		responseCache.Discard()
+		s.History.AddMessage("user", repairPrompt(err))
+	}
+}
+
+// StreamsResponses reports whether the chunks of responses are printed as they arrive in stream mode.
+// A response is printed at once after the post_response hooks, which may change or reject it,
+// and after the validation when invalid responses are repaired, unless only the extracted value is printed.
+func (s *Session) StreamsResponses() bool {
+	hasSchema := s.History.JsonSchema != nil && s.History.JsonSchema.Schema != nil
+	return s.Stream && !s.Hooks.Has(HookPostResponse) && (!hasSchema || s.RepairRetries == 0 || s.ExtractPath != nil)
+}
+
+func (s *Session) printMessage(w MessageWriter, role string, printer ResponsePrinter, message string) error {
+	if err := w.MessageStart(role); err != nil {
+		return err
+	}
+	if err := printer.PrintMessage(message); err != nil {
+		return err
+	}
+	return w.MessageEnd(role)
+}
+
+func (s *Session) chatCompletion(ctx context.Context, request *payload.Request, printer ResponsePrinter) (*payload.Response, error) {
+	if !s.Stream {
+		return s.Client.ChatCompletion(request, ctx)
+	}
+
+	message := &payload.Message{}
+	var usage *payload.Usage
+	err := s.Client.ChatCompletionStream(request, ctx, func(response *payload.Response) error {
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+		if r := response.Message.Role; r != "" {
+			message.Role = r
+		}
+		chunk := response.Message.Content
+		message.Content += chunk
+		return printer.PrintChunk(chunk)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payload.Response{Message: message, Usage: usage}, printer.EndStream()
+}
+
+func (s *Session) newResponsePrinter(w io.Writer) ResponsePrinter {
+	if s.ExtractPath != nil {
+		return NewExtractPrinter(w, s.Verb, s.ExtractPath)
+	}
+	if s.CodeSelector != nil {
+		printer := NewCodePrinter(w, s.Verb, s.CodeSelector, s.CodeWrite)
+		printer.Overwrite = s.CodeOverwrite
+		return printer
+	}
+	return NewTextPrinter(w, s.Verb)
+}
+
+func (s *Session) responseSchema() (*jsonschema.Schema, error) {
+	if s.History.JsonSchema == nil || s.History.JsonSchema.Schema == nil {
+		return nil, nil
+	}
+	return jsonschema.Compile(*s.History.JsonSchema.Schema)
+}
+
+func (s *Session) validateResponse(schema *jsonschema.Schema, message string) error {
+	if schema == nil {
+		return nil
+	}
+	err := schema.ValidateJSON([]byte(message))
+	var verr *jsonschema.ValidationError
+	if errors.As(err, &verr) {
+		return &ResponseValidationError{
+			Response: message,
+			Errors:   verr.Errors,
+		}
+	}
+	return err
+}
+
+func repairPrompt(err error) string {
+	var buf strings.Builder
+	buf.WriteString("The previous response does not conform to the provided JSON schema.\n\n## Errors\n\n")
+	var verr *ResponseValidationError
+	if errors.As(err, &verr) {
+		for _, e := range verr.Errors {
+			fmt.Fprintf(&buf, "- %s\n", e)
+		}
+	} else {
+		fmt.Fprintf(&buf, "- %s\n", err)
+	}
+	buf.WriteString("\nPlease respond again with JSON only, following the JSON schema.")
+	return buf.String()
+}
@@ -0,0 +1,284 @@
+package afa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/monochromegane/afa/internal/cache"
+	"github.com/monochromegane/afa/internal/llm/llmtest"
+	"github.com/monochromegane/afa/internal/llm/openai"
+	"github.com/monochromegane/afa/internal/payload"
+)
+
+type stubClient struct {
+	responses []string
+	requests  int
+}
+
+func (c *stubClient) ChatCompletion(request *payload.Request, ctx context.Context) (*payload.Response, error) {
+	content := c.responses[c.requests]
+	c.requests++
+	return &payload.Response{Message: &payload.Message{Role: "assistant", Content: content}}, nil
+}
+
+func (c *stubClient) ChatCompletionStream(request *payload.Request, ctx context.Context, onData func(*payload.Response) error) error {
+	response, err := c.ChatCompletion(request, ctx)
+	if err != nil {
+		return err
+	}
+	return onData(response)
+}
+
+func TestChatCompletionAndPrintRepairsInvalidResponse(t *testing.T) {
+	schema := json.RawMessage(`{"type":"object","properties":{"a":{"type":"string"}},"additionalProperties":false,"required":["a"]}`)
+	tests := []struct {
+		name          string
+		repairRetries int
+		responses     []string
+		output        string
+		valid         bool
+	}{
+		{
+			name:          "valid response",
+			repairRetries: 0,
+			responses:     []string{`{"a":"b"}`},
+			output:        "{\"a\":\"b\"}\n",
+			valid:         true,
+		},
+		{
+			name:          "repaired response",
+			repairRetries: 1,
+			responses:     []string{`{"b":"a"}`, `{"a":"b"}`},
+			output:        "{\"a\":\"b\"}\n",
+			valid:         true,
+		},
+		{
+			name:          "invalid response",
+			repairRetries: 1,
+			responses:     []string{`{"b":"a"}`, `not json`},
+			output:        "",
+			valid:         false,
+		},
+	}
+
+	for _, tt := range tests {
+		// In stream mode, only the response that passes the validation is printed.
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s stream %v", tt.name, stream), func(t *testing.T) {
+				client := &stubClient{responses: tt.responses}
+				session, err := NewSession(NewSecret(""), NewHistory("model", "schema", &schema), NewMemoryStorage(), SessionOptions{RepairRetries: tt.repairRetries, Stream: stream})
+				if err != nil {
+					t.Fatal(err)
+				}
+				session.Client = client
+
+				var buf bytes.Buffer
+				err = session.chatCompletionAndPrint(context.Background(), "prompt", &DefaultMessageWriter{&buf})
+				var verr *ResponseValidationError
+				if tt.valid && err != nil {
+					t.Errorf("chatCompletionAndPrint should not return error, but got %v", err)
+				}
+				if !tt.valid && !errors.As(err, &verr) {
+					t.Errorf("chatCompletionAndPrint should return ResponseValidationError, but got %v", err)
+				}
+				if client.requests != len(tt.responses) {
+					t.Errorf("chatCompletionAndPrint should request %d times, but requested %d times", len(tt.responses), client.requests)
+				}
+				if buf.String() != tt.output {
+					t.Errorf("chatCompletionAndPrint should output %q, but got %q", tt.output, buf.String())
+				}
+			})
+		}
+	}
+}
+
+func TestSessionStreamsResponses(t *testing.T) {
+	schema := json.RawMessage(`{"type":"object"}`)
+	tests := []struct {
+		name          string
+		schema        bool
+		repairRetries int
+		extract       string
+		hook          bool
+		want          bool
+	}{
+		{name: "text", want: true},
+		{name: "schema", schema: true, want: true},
+		{name: "schema with repair", schema: true, repairRetries: 1, want: false},
+		{name: "extract with repair", schema: true, repairRetries: 1, extract: ".a", want: true},
+		{name: "post_response hook", hook: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := NewHistory("model", "", nil)
+			if tt.schema {
+				history = NewHistory("model", "schema", &schema)
+			}
+			session, err := NewSession(NewSecret(""), history, NewMemoryStorage(), SessionOptions{Stream: true, RepairRetries: tt.repairRetries})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := session.SetExtractPath(tt.extract); err != nil {
+				t.Fatal(err)
+			}
+			if tt.hook {
+				session.Hooks = NewHooks(&HooksOption{PostResponse: [][]string{{"true"}}}, "session")
+			}
+			if got := session.StreamsResponses(); got != tt.want {
+				t.Errorf("StreamsResponses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatCompletionAndPrintCachesValidResponse(t *testing.T) {
+	schema := json.RawMessage(`{"type":"object","properties":{"a":{"type":"string"}},"additionalProperties":false,"required":["a"]}`)
+	server := llmtest.NewServer(&llmtest.Response{Content: `{"b":"a"}`}, &llmtest.Response{Content: `{"a":"b"}`})
+	server.APIKey = "test-key"
+	defer server.Close()
+
+	responseCache := cache.New(t.TempDir(), 0, 0)
+	responseCache.Defer = true
+	session, err := NewSession(NewSecret("test-key"), NewHistory("model", "schema", &schema), NewMemoryStorage(), SessionOptions{RepairRetries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Client = server.Client()
+
+	var buf bytes.Buffer
+	if err := session.chatCompletionAndPrint(cache.NewContext(context.Background(), responseCache), "prompt", &DefaultMessageWriter{&buf}); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := responseCache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 1 {
+		t.Errorf("cache entries = %d, want only the repaired response", stats.Entries)
+	}
+}
+
+func TestSessionStart(t *testing.T) {
+	storage := NewMemoryStorage()
+	if err := storage.MkdirAll("config", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	systemPromptPath := "config/system.tmpl"
+	userPromptPath := "config/user.tmpl"
+	if err := storage.WriteFile(systemPromptPath, []byte("You are a test."), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile(userPromptPath, []byte("{{ .Message }}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		interactive bool
+		stream      bool
+		message     string
+		input       string
+		responses   []*llmtest.Response
+		output      string
+		messages    []string
+	}{
+		{
+			name:      "script",
+			message:   "hi",
+			responses: []*llmtest.Response{{Content: "Hello"}},
+			output:    "Hello\n",
+			messages:  []string{"system: You are a test.", "user: hi", "assistant: Hello"},
+		},
+		{
+			name:      "script with stream",
+			stream:    true,
+			message:   "hi",
+			responses: []*llmtest.Response{{Chunks: []string{"Hel", "lo"}}},
+			output:    "Hello\n",
+			messages:  []string{"system: You are a test.", "user: hi", "assistant: Hello"},
+		},
+		{
+			name:        "interactive",
+			interactive: true,
+			input:       "hi\n\nbye\nexit\nignored\n",
+			responses:   []*llmtest.Response{{Content: "Hello"}, {Content: "Goodbye"}},
+			output:      "> Hello\n> > Goodbye\n> ",
+			messages:    []string{"system: You are a test.", "user: hi", "assistant: Hello", "user: bye", "assistant: Goodbye"},
+		},
+		{
+			name:        "interactive with stream",
+			interactive: true,
+			stream:      true,
+			input:       "hi\n",
+			responses:   []*llmtest.Response{{Chunks: []string{"Hel", "lo"}}},
+			output:      "> Hello\n> ",
+			messages:    []string{"system: You are a test.", "user: hi", "assistant: Hello"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := llmtest.NewServer(tt.responses...)
+			server.APIKey = "test-key"
+			defer server.Close()
+
+			session, err := NewSession(NewSecret("test-key"), NewHistory("model", "", nil), storage, SessionOptions{
+				SystemPromptTemplate: systemPromptPath,
+				UserPromptTemplate:   userPromptPath,
+				Interactive:          tt.interactive,
+				Stream:               tt.stream,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			session.Client = server.Client()
+
+			var buf bytes.Buffer
+			err = session.Start(tt.message, "", nil, context.Background(), strings.NewReader(tt.input), &DefaultMessageWriter{&buf})
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if buf.String() != tt.output {
+				t.Errorf("Start() output = %q, want %q", buf.String(), tt.output)
+			}
+			messages := []string{}
+			for _, message := range session.History.Messages {
+				messages = append(messages, fmt.Sprintf("%s: %s", message.Role, message.Content))
+			}
+			if strings.Join(messages, "\n") != strings.Join(tt.messages, "\n") {
+				t.Errorf("History.Messages = %q, want %q", messages, tt.messages)
+			}
+			if len(server.Requests()) != len(tt.responses) {
+				t.Errorf("Start() requested %d times, want %d", len(server.Requests()), len(tt.responses))
+			}
+		})
+	}
+}
+
+func TestSessionStartError(t *testing.T) {
+	server := llmtest.NewServer(&llmtest.Response{StatusCode: 429, ErrorCode: "rate_limit_exceeded", ErrorMessage: "Rate limit reached."})
+	defer server.Close()
+
+	storage := NewMemoryStorage()
+	promptPath := "prompt.tmpl"
+	if err := storage.WriteFile(promptPath, []byte("{{ .Message }}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	session, err := NewSession(NewSecret(""), NewHistory("model", "", nil), storage, SessionOptions{SystemPromptTemplate: promptPath, UserPromptTemplate: promptPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Client = server.Client()
+
+	var buf bytes.Buffer
+	err = session.Start("hi", "", nil, context.Background(), strings.NewReader(""), &DefaultMessageWriter{&buf})
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 {
+		t.Errorf("Start() error = %v, want the rate limit error", err)
+	}
+}
@@ -0,0 +1,236 @@
+package afa
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	storageConfigDir = "config"
+	storageCacheDir  = "cache"
+)
+
+// Storage stores the configuration, templates, schemas and sessions of the workspace.
+// Names are slash-separated paths as in fs.FS, under "config" or "cache". (e.g. "config/option.json", "cache/sessions/NAME.json")
+type Storage interface {
+	fs.FS
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Remove(name string) error
+}
+
+// DiskStorage stores files in the configuration and cache directories.
+type DiskStorage struct {
+	ConfigDir string
+	CacheDir  string
+}
+
+func NewDiskStorage(configDir, cacheDir string) *DiskStorage {
+	return &DiskStorage{ConfigDir: configDir, CacheDir: cacheDir}
+}
+
+// Path returns the path of the name on disk.
+func (s *DiskStorage) Path(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", fs.ErrInvalid
+	}
+	root, rest, _ := strings.Cut(name, "/")
+	switch root {
+	case storageConfigDir:
+		return filepath.Join(s.ConfigDir, filepath.FromSlash(rest)), nil
+	case storageCacheDir:
+		return filepath.Join(s.CacheDir, filepath.FromSlash(rest)), nil
+	}
+	return "", fs.ErrNotExist
+}
+
+func (s *DiskStorage) Open(name string) (fs.File, error) {
+	if name == "." {
+		return newMemoryDir(&memoryFileInfo{name: ".", mode: fs.ModeDir | 0o700}, []fs.DirEntry{
+			fs.FileInfoToDirEntry(&memoryFileInfo{name: storageCacheDir, mode: fs.ModeDir | 0o700}),
+			fs.FileInfoToDirEntry(&memoryFileInfo{name: storageConfigDir, mode: fs.ModeDir | 0o700}),
+		}), nil
+	}
+	p, err := s.Path(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return os.Open(p)
+}
+
+func (s *DiskStorage) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	p, err := s.Path(name)
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	return os.WriteFile(p, data, perm)
+}
+
+func (s *DiskStorage) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := s.Path(name)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return os.MkdirAll(p, perm)
+}
+
+func (s *DiskStorage) Remove(name string) error {
+	p, err := s.Path(name)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return os.Remove(p)
+}
+
+// MemoryStorage stores files in memory, for tests and programs that host afa without touching the disk.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	files map[string]*memoryFileInfo
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: map[string]*memoryFileInfo{
+		".": {name: ".", mode: fs.ModeDir | 0o700, modTime: time.Now()},
+	}}
+}
+
+func (s *MemoryStorage) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !info.IsDir() {
+		return &memoryFile{info: info, Reader: bytes.NewReader(info.data)}, nil
+	}
+	entries := []fs.DirEntry{}
+	for child, childInfo := range s.files {
+		if child != "." && path.Dir(child) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(childInfo))
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return newMemoryDir(info, entries), nil
+}
+
+func (s *MemoryStorage) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if parent, ok := s.files[path.Dir(name)]; !ok || !parent.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrNotExist}
+	}
+	if info, ok := s.files[name]; ok && info.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	s.files[name] = &memoryFileInfo{
+		name:    path.Base(name),
+		data:    bytes.Clone(data),
+		mode:    perm.Perm(),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+func (s *MemoryStorage) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if info, ok := s.files[dir]; ok {
+			if !info.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+			}
+			continue
+		}
+		s.files[dir] = &memoryFileInfo{name: path.Base(dir), mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Remove(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	for child := range s.files {
+		if path.Dir(child) == name && child != "." {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+	}
+	delete(s.files, name)
+	return nil
+}
+
+type memoryFileInfo struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memoryFileInfo) Name() string       { return i.name }
+func (i *memoryFileInfo) Size() int64        { return int64(len(i.data)) }
+func (i *memoryFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memoryFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memoryFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memoryFileInfo) Sys() any           { return nil }
+
+type memoryFile struct {
+	*bytes.Reader
+	info *memoryFileInfo
+}
+
+func (f *memoryFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memoryFile) Close() error               { return nil }
+
+type memoryDir struct {
+	info    *memoryFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func newMemoryDir(info *memoryFileInfo, entries []fs.DirEntry) *memoryDir {
+	return &memoryDir{info: info, entries: entries}
+}
+
+func (d *memoryDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memoryDir) Close() error               { return nil }
+
+func (d *memoryDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memoryDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,154 @@
+package afa
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	if err := storage.WriteFile("config/option.json", []byte("{}"), 0o600); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("WriteFile() without the directory error = %v, want ErrNotExist", err)
+	}
+	if err := storage.MkdirAll("config/templates/user", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile("config/option.json", []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile("config/templates/user/default.tmpl", []byte("{{ .Message }}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(storage, "config/option.json", "config/templates/user/default.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Remove("config/templates"); err == nil {
+		t.Errorf("Remove() of a non-empty directory should fail")
+	}
+	if err := storage.Remove("config/option.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(storage, "config/option.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() after Remove error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestDiskStorage(t *testing.T) {
+	configDir := filepath.Join(t.TempDir(), "config")
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	storage := NewDiskStorage(configDir, cacheDir)
+	if err := storage.MkdirAll("cache/sessions", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteFile("cache/sessions/a.json", []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "sessions", "a.json")); err != nil {
+		t.Errorf("WriteFile() should write to the cache directory: %v", err)
+	}
+	if _, err := storage.Open("other/a.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() outside the directories error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestWorkSpaceWithMemoryStorage(t *testing.T) {
+	workSpace := NewWorkSpaceWithStorage(NewMemoryStorage(), t.TempDir())
+	if !workSpace.IsNotExist() {
+		t.Fatal("IsNotExist() should be true before Setup")
+	}
+	if err := workSpace.Setup(NewOption(), NewSecret("key")); err != nil {
+		t.Fatal(err)
+	}
+	if workSpace.IsNotExist() {
+		t.Fatal("IsNotExist() should be false after Setup")
+	}
+
+	secret, err := workSpace.LoadSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.OpenAI.ApiKey != "key" {
+		t.Errorf("LoadSecret() = %q", secret.OpenAI.ApiKey)
+	}
+	templates, err := workSpace.ListTemplates("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates[0] != "default" {
+		t.Errorf("ListTemplates() = %q", templates)
+	}
+
+	sessionPath, err := workSpace.SessionPath("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workSpace.SetupSession(sessionPath, "model", "command_suggestion"); err != nil {
+		t.Fatal(err)
+	}
+	history, err := workSpace.LoadHistory(sessionPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history.AddMessage("user", "hi")
+	if err := workSpace.SaveSession("a", "1", history); err != nil {
+		t.Fatal(err)
+	}
+	names, histories, err := workSpace.ListSessions(10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "a" || histories[0].FirstUserPrompt() != "hi" || histories[0].JsonSchema == nil {
+		t.Errorf("ListSessions() = %q", names)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"default", "command_suggestion", "2024-01-02_03-04-05", "my template"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) = %v, want nil", name, err)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "../secret", "a/b", `a\b`, "/etc/passwd", "..secret"} {
+		var nerr *InvalidNameError
+		if err := ValidateName(name); !errors.As(err, &nerr) {
+			t.Errorf("ValidateName(%q) = %v, want InvalidNameError", name, err)
+		}
+	}
+
+	workSpace := NewWorkSpaceWithStorage(NewMemoryStorage(), t.TempDir())
+	if _, err := workSpace.SchemaPath("../secret"); err == nil {
+		t.Errorf("SchemaPath() should reject a name out of the directory")
+	}
+	if _, err := workSpace.TemplatePath("user", "../../secret"); err == nil {
+		t.Errorf("TemplatePath() should reject a name out of the directory")
+	}
+}
+
+func TestNewSessionName(t *testing.T) {
+	workSpace := NewWorkSpaceWithStorage(NewMemoryStorage(), t.TempDir())
+	if err := workSpace.Setup(NewOption(), NewSecret("")); err != nil {
+		t.Fatal(err)
+	}
+	startedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if name := workSpace.NewSessionName(startedAt); name != "2026-01-02_03-04-05" {
+		t.Errorf("NewSessionName() = %q, want the name from the time", name)
+	}
+
+	// Sessions started in the same second get another name.
+	if err := workSpace.SaveHistory("2026-01-02_03-04-05", NewHistory("model", "", nil)); err != nil {
+		t.Fatal(err)
+	}
+	processPath, _ := workSpace.ProcessPath("2026-01-02_03-04-05_1")
+	if err := os.WriteFile(processPath, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if name := workSpace.NewSessionName(startedAt); name != "2026-01-02_03-04-05_2" {
+		t.Errorf("NewSessionName() = %q, want a name with a suffix", name)
+	}
+}
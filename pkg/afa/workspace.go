@@ -0,0 +1,807 @@
+package afa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type WorkSpace struct {
+	Storage Storage
+	// CacheDir is the directory on disk for runtime files such as sockets, PID files and traces.
+	CacheDir string
+
+	DirPerm  os.FileMode
+	FilePerm os.FileMode
+}
+
+func NewWorkSpace(configDir, cacheDir string) *WorkSpace {
+	return NewWorkSpaceWithStorage(NewDiskStorage(configDir, cacheDir), cacheDir)
+}
+
+func NewWorkSpaceWithStorage(storage Storage, cacheDir string) *WorkSpace {
+	return &WorkSpace{
+		Storage:  storage,
+		CacheDir: cacheDir,
+		DirPerm:  os.FileMode(0700),
+		FilePerm: os.FileMode(0600),
+	}
+}
+
+func (w *WorkSpace) IsNotExist() bool {
+	return !w.Exists(w.SecretPath())
+}
+
+// SetupIfKeyGiven sets up the workspace without the secret file when it does not exist
+// and the API key is given by the environment variable. It reports whether the workspace exists.
+func (w *WorkSpace) SetupIfKeyGiven() (bool, error) {
+	if !w.IsNotExist() {
+		return true, nil
+	}
+	if os.Getenv(OpenAIAPIKeyEnv) == "" {
+		return false, nil
+	}
+	return true, w.Setup(NewOption(), nil)
+}
+
+// Exists reports whether the name exists in the storage.
+func (w *WorkSpace) Exists(name string) bool {
+	_, err := fs.Stat(w.Storage, name)
+	return err == nil
+}
+
+// DisplayPath returns the path on disk of the name for messages, or the name if it is not on disk.
+func (w *WorkSpace) DisplayPath(name string) string {
+	if storage, ok := w.DiskStorage(); ok {
+		if p, err := storage.Path(name); err == nil {
+			return p
+		}
+	}
+	return name
+}
+
+func (w *WorkSpace) Setup(option *Option, secret *Secret) error {
+	if err := w.setupDirs(); err != nil {
+		return err
+	}
+	return w.setupFiles(option, secret)
+}
+
+func (w *WorkSpace) setupDirs() error {
+	for _, dir := range []string{
+		w.TemplateDir("system"),
+		w.TemplateDir("user"),
+		w.SchemaDir(),
+		w.SessionsDir(),
+		w.SidDir(),
+	} {
+		if err := w.Storage.MkdirAll(dir, w.DirPerm); err != nil {
+			return err
+		}
+	}
+	for _, dir := range []string{
+		w.SocketDir(),
+		w.TraceDir(),
+		w.ResponseCacheDir(),
+	} {
+		if err := w.MkDirAllIfNotExist(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WorkSpace) setupFiles(option *Option, secret *Secret) error {
+	if err := w.writeFileIfNotExist(
+		path.Join(w.TemplateDir("system"), "default.tmpl"),
+		[]byte("You are a helpful assistant."),
+	); err != nil {
+		return err
+	}
+
+	if err := w.writeFileIfNotExist(
+		path.Join(w.TemplateDir("user"), "default.tmpl"),
+		[]byte("{{ .Message }}\n{{ if .MessageStdin }}\n```\n{{ .MessageStdin }}```\n{{- end }}\n{{ range .Files }}\n- File: {{ .Name }}\\n```\n{{ .Content }}```\n{{ end -}}"),
+	); err != nil {
+		return err
+	}
+
+	if err := w.writeFileIfNotExist(
+		path.Join(w.SchemaDir(), "command_suggestion.json"),
+		[]byte("{\n  \"type\": \"object\",\n  \"properties\": {\n    \"suggested_command\": {\n      \"type\": \"string\"\n    }\n  },\n  \"additionalProperties\": false,\n  \"required\": [\n    \"suggested_command\"\n  ]\n}"),
+	); err != nil {
+		return err
+	}
+
+	jsonOption, err := json.MarshalIndent(option, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := w.writeFileIfNotExist(w.OptionPath(), jsonOption); err != nil {
+		return err
+	}
+
+	// The secret is not written when the API key is given by the environment variable.
+	if secret == nil {
+		return nil
+	}
+	jsonSecret, err := json.MarshalIndent(secret, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := w.writeFileIfNotExist(w.SecretPath(), jsonSecret); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InvalidNameError is returned when the name of a template, schema or session is not a single element of a path.
+type InvalidNameError struct {
+	Name string
+}
+
+func (e *InvalidNameError) Error() string {
+	return fmt.Sprintf("Invalid name %q. A name must not be empty or contain /, \\ or \"..\".", e.Name)
+}
+
+// ValidateName reports whether the name stays in its directory. (e.g. "../secret" is invalid)
+func ValidateName(name string) error {
+	if !filepath.IsLocal(name) || name == "." || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return &InvalidNameError{Name: name}
+	}
+	return nil
+}
+
+// Paths of templates, schemas, sessions and sids are names in the storage.
+
+func (w *WorkSpace) TemplateDir(role string) string {
+	return path.Join(storageConfigDir, "templates", role)
+}
+
+func (w *WorkSpace) TemplatePath(role, name string) (string, error) {
+	if err := ValidateName(role); err != nil {
+		return "", err
+	}
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return path.Join(w.TemplateDir(role), fmt.Sprintf("%s.tmpl", name)), nil
+}
+
+func (w *WorkSpace) SchemaDir() string {
+	return path.Join(storageConfigDir, "schemas")
+}
+
+func (w *WorkSpace) SchemaPath(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return path.Join(w.SchemaDir(), fmt.Sprintf("%s.json", name)), nil
+}
+
+func (w *WorkSpace) ProviderDir() string {
+	return path.Join(storageConfigDir, "providers")
+}
+
+// RegisterProviderPlugins registers the plugins in the provider directory. Plugins must be on disk to be executed.
+func (w *WorkSpace) RegisterProviderPlugins() error {
+	storage, ok := w.DiskStorage()
+	if !ok {
+		return nil
+	}
+	dir, err := storage.Path(w.ProviderDir())
+	if err != nil {
+		return err
+	}
+	return RegisterProviderPlugins(dir)
+}
+
+func (w *WorkSpace) SessionsDir() string {
+	return path.Join(storageCacheDir, "sessions")
+}
+
+func (w *WorkSpace) SessionPath(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return path.Join(w.SessionsDir(), fmt.Sprintf("%s.json", name)), nil
+}
+
+func (w *WorkSpace) SidDir() string {
+	return path.Join(storageCacheDir, "sid")
+}
+
+func (w *WorkSpace) SidPath(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return path.Join(w.SidDir(), fmt.Sprintf("%s.sid", name)), nil
+}
+
+// NewSessionName returns the name of a new session from the time, with a suffix when the name is taken.
+func (w *WorkSpace) NewSessionName(startedAt time.Time) string {
+	base := startedAt.Format("2006-01-02_15-04-05")
+	name := base
+	for i := 1; w.sessionNameTaken(name); i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	return name
+}
+
+func (w *WorkSpace) sessionNameTaken(name string) bool {
+	// Names from the time are always valid.
+	sessionPath, _ := w.SessionPath(name)
+	if w.Exists(sessionPath) {
+		return true
+	}
+	processPath, _ := w.ProcessPath(name)
+	logPath, _ := w.DetachedLogPath(name)
+	for _, path := range []string{processPath, logPath} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Paths of runtime files are on disk.
+
+func (w *WorkSpace) SocketDir() string {
+	return path.Join(w.CacheDir, "sockets")
+}
+
+func (w *WorkSpace) SocketPath(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return path.Join(w.SocketDir(), fmt.Sprintf("%s.sock", name)), nil
+}
+
+func (w *WorkSpace) ProcessPath(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return path.Join(w.SocketDir(), fmt.Sprintf("%s.pid", name)), nil
+}
+
+func (w *WorkSpace) DetachedLogPath(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return path.Join(w.SocketDir(), fmt.Sprintf("%s.log", name)), nil
+}
+
+func (w *WorkSpace) TraceDir() string {
+	return path.Join(w.CacheDir, "traces")
+}
+
+func (w *WorkSpace) ResponseCacheDir() string {
+	return path.Join(w.CacheDir, "responses")
+}
+
+func (w *WorkSpace) TracePath(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return path.Join(w.TraceDir(), fmt.Sprintf("%s.jsonl", name)), nil
+}
+
+func (w *WorkSpace) OptionPath() string {
+	return path.Join(storageConfigDir, "option.json")
+}
+
+func (w *WorkSpace) SecretPath() string {
+	return path.Join(storageConfigDir, "secret.json")
+}
+
+func (w *WorkSpace) EncryptionSaltPath() string {
+	return path.Join(storageConfigDir, "encryption.salt")
+}
+
+func (w *WorkSpace) SetupSession(sessionPath, model, schema string) error {
+	rawSchema, err := w.LoadSchema(schema)
+	if schema != "" && err != nil {
+		return err
+	}
+
+	history := NewHistory(model, schema, rawSchema)
+	jsonSession, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return w.writeFileIfNotExist(sessionPath, jsonSession)
+}
+
+func (w *WorkSpace) LoadSchema(schema string) (*json.RawMessage, error) {
+	schemaPath, err := w.SchemaPath(schema)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fs.ReadFile(w.Storage, schemaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw json.RawMessage
+	if err := json.Unmarshal(file, &raw); err != nil {
+		return nil, err
+	}
+
+	return &raw, nil
+}
+
+func (w *WorkSpace) SaveSession(sessionName, runsOn string, history *History) error {
+	if err := w.SaveHistory(sessionName, history); err != nil {
+		return err
+	}
+
+	sidPath, err := w.SidPath(runsOn)
+	if err != nil {
+		return err
+	}
+	return w.WriteFile(sidPath, []byte(sessionName))
+}
+
+func (w *WorkSpace) SaveHistory(sessionName string, history *History) error {
+	jsonSession, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	sessionPath, err := w.SessionPath(sessionName)
+	if err != nil {
+		return err
+	}
+	return w.WriteFile(sessionPath, jsonSession)
+}
+
+func (w *WorkSpace) RemoveSession(sessionName string) error {
+	sessionPath, err := w.SessionPath(sessionName)
+	if err != nil {
+		return err
+	}
+	return w.Storage.Remove(sessionPath)
+}
+
+func (w *WorkSpace) LoadHistory(path string) (*History, error) {
+	file, err := fs.ReadFile(w.Storage, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var history History
+	if err := json.Unmarshal(file, &history); err != nil {
+		return nil, err
+	}
+
+	return &history, nil
+}
+
+// DiskStorage returns the storage on disk under the encryption, if the workspace is on disk.
+func (w *WorkSpace) DiskStorage() (*DiskStorage, bool) {
+	storage, ok := w.rawStorage().(*DiskStorage)
+	return storage, ok
+}
+
+// rawStorage returns the storage without the encryption.
+func (w *WorkSpace) rawStorage() Storage {
+	if storage, ok := w.Storage.(*EncryptedStorage); ok {
+		return storage.Storage
+	}
+	return w.Storage
+}
+
+// EnableEncryption encrypts the secret and the sessions written after this, with the key derived from secret.
+// Files written without the encryption are still read as they are.
+func (w *WorkSpace) EnableEncryption(secret func() ([]byte, error)) {
+	if _, ok := w.Storage.(*EncryptedStorage); ok {
+		return
+	}
+	storage := w.Storage
+	cipher := NewCipher(secret, nil)
+	cipher.Salt = func() ([]byte, error) {
+		return w.loadEncryptionSalt(storage, cipher)
+	}
+	w.Storage = &EncryptedStorage{Storage: storage, Cipher: cipher, Encrypted: w.isEncryptionTarget}
+}
+
+func (w *WorkSpace) isEncryptionTarget(name string) bool {
+	return name == w.SecretPath() || path.Dir(name) == w.SessionsDir()
+}
+
+func (w *WorkSpace) encrypts(name string) bool {
+	_, ok := w.Storage.(*EncryptedStorage)
+	return ok && w.isEncryptionTarget(name)
+}
+
+// loadEncryptionSalt returns the salt of the workspace, which is created with the first encrypted file.
+func (w *WorkSpace) loadEncryptionSalt(storage Storage, cipher *Cipher) ([]byte, error) {
+	data, err := fs.ReadFile(storage, w.EncryptionSaltPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		salt, err := NewSalt()
+		if err != nil {
+			return nil, err
+		}
+		check, err := cipher.seal([]byte(encryptionCheck), salt)
+		if err != nil {
+			return nil, err
+		}
+		return salt, storage.WriteFile(w.EncryptionSaltPath(), check, w.FilePerm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("Invalid salt file %s.", w.DisplayPath(w.EncryptionSaltPath()))
+	}
+	check, err := cipher.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	if string(check) != encryptionCheck {
+		return nil, ErrDecrypt
+	}
+	return data[len(encryptionMagic) : len(encryptionMagic)+saltSize], nil
+}
+
+// EncryptionFiles returns the names of the secret and the session files by whether they are encrypted.
+func (w *WorkSpace) EncryptionFiles() (encrypted, plain []string, err error) {
+	storage := w.rawStorage()
+	names := []string{}
+	if w.Exists(w.SecretPath()) {
+		names = append(names, w.SecretPath())
+	}
+	entries, err := fs.ReadDir(storage, w.SessionsDir())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, path.Join(w.SessionsDir(), entry.Name()))
+		}
+	}
+
+	for _, name := range names {
+		data, err := fs.ReadFile(storage, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if IsEncrypted(data) {
+			encrypted = append(encrypted, name)
+		} else {
+			plain = append(plain, name)
+		}
+	}
+	return encrypted, plain, nil
+}
+
+// EncryptFiles encrypts the secret and the session files written without the encryption.
+// It returns the names of the encrypted files.
+func (w *WorkSpace) EncryptFiles() ([]string, error) {
+	storage, ok := w.Storage.(*EncryptedStorage)
+	if !ok {
+		return nil, ErrEncryptionDisabled
+	}
+	_, plain, err := w.EncryptionFiles()
+	if err != nil {
+		return nil, err
+	}
+	return plain, w.rewriteFiles(plain, storage.Cipher.Encrypt)
+}
+
+// DecryptFiles decrypts the encrypted secret and session files, to disable the encryption.
+// It returns the names of the decrypted files.
+func (w *WorkSpace) DecryptFiles() ([]string, error) {
+	storage, ok := w.Storage.(*EncryptedStorage)
+	if !ok {
+		return nil, ErrEncryptionDisabled
+	}
+	encrypted, _, err := w.EncryptionFiles()
+	if err != nil {
+		return nil, err
+	}
+	return encrypted, w.rewriteFiles(encrypted, storage.Cipher.Decrypt)
+}
+
+// rewriteFiles converts the files, keeping their modification times to keep the order of sessions.
+func (w *WorkSpace) rewriteFiles(names []string, convert func([]byte) ([]byte, error)) error {
+	storage := w.rawStorage()
+	for _, name := range names {
+		info, err := fs.Stat(storage, name)
+		if err != nil {
+			return err
+		}
+		data, err := fs.ReadFile(storage, name)
+		if err != nil {
+			return err
+		}
+		converted, err := convert(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", w.DisplayPath(name), err)
+		}
+		if err := storage.WriteFile(name, converted, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if disk, ok := storage.(*DiskStorage); ok {
+			if p, err := disk.Path(name); err == nil {
+				if err := os.Chtimes(p, info.ModTime(), info.ModTime()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (w *WorkSpace) LoadOption() (*Option, error) {
+	option := NewOption()
+
+	file, err := fs.ReadFile(w.Storage, w.OptionPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return option, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(file, option); err != nil {
+		return nil, err
+	}
+
+	return option, nil
+}
+
+// LoadSecret loads the secret file, which may be missing when the API key is given by the environment variable.
+func (w *WorkSpace) LoadSecret() (*Secret, error) {
+	file, err := fs.ReadFile(w.Storage, w.SecretPath())
+	if errors.Is(err, fs.ErrNotExist) && os.Getenv(OpenAIAPIKeyEnv) != "" {
+		file, err = []byte("{}"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var secret Secret
+	if err := json.Unmarshal(file, &secret); err != nil {
+		return nil, err
+	}
+	if err := secret.Resolve(); err != nil {
+		return nil, err
+	}
+
+	return &secret, nil
+}
+
+func (w *WorkSpace) ListSessions(count int, orderByModify bool) ([]string, []*History, error) {
+	names := []string{}
+	histories := []*History{}
+
+	dirEntories, err := fs.ReadDir(w.Storage, w.SessionsDir())
+	if err != nil {
+		return nil, nil, err
+	}
+	files := []fs.FileInfo{}
+	for _, dirEntry := range dirEntories {
+		if dirEntry.IsDir() {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, info)
+	}
+
+	if orderByModify {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].ModTime().After(files[j].ModTime())
+		})
+	} else {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Name() > files[j].Name()
+		})
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		fileName := file.Name()
+		sessionName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+
+		sessionPath, err := w.SessionPath(sessionName)
+		if err != nil {
+			return nil, nil, err
+		}
+		history, err := w.LoadHistory(sessionPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		names = append(names, sessionName)
+		histories = append(histories, history)
+
+		if len(names) >= count {
+			break
+		}
+	}
+
+	return names, histories, nil
+}
+
+func (w *WorkSpace) LoadProcess(name string) (*SessionProcess, error) {
+	processPath, err := w.ProcessPath(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.ReadFile(processPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var process SessionProcess
+	if err := json.Unmarshal(file, &process); err != nil {
+		return nil, err
+	}
+	return &process, nil
+}
+
+// LockSession creates the PID file of the session exclusively, so that only one process serves the session.
+// A PID file left by a process that has gone is removed with its socket.
+func (w *WorkSpace) LockSession(name string, process *SessionProcess) error {
+	data, err := json.Marshal(process)
+	if err != nil {
+		return err
+	}
+
+	path, err := w.ProcessPath(name)
+	if err != nil {
+		return err
+	}
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, w.FilePerm)
+		if err == nil {
+			if _, err := file.Write(data); err != nil {
+				file.Close()
+				os.Remove(path)
+				return err
+			}
+			return file.Close()
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		owner, err := w.LoadProcess(name)
+		if err != nil {
+			// The owner may be writing the PID file.
+			if info, statErr := os.Stat(path); statErr == nil && time.Since(info.ModTime()) < SessionStartupGrace {
+				return &SessionInUseError{Name: name}
+			}
+			owner = nil
+		}
+		if owner != nil && (owner.Alive() || owner.Starting()) {
+			return &SessionInUseError{Name: name, PID: owner.PID}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if owner != nil && owner.Socket != "" {
+			if err := os.Remove(owner.Socket); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+}
+
+func (w *WorkSpace) ListProcesses() ([]string, []*SessionProcess, error) {
+	names, err := w.listNames(os.DirFS(w.SocketDir()), ".", ".pid")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	processes := []*SessionProcess{}
+	for _, name := range names {
+		process, err := w.LoadProcess(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		processes = append(processes, process)
+	}
+	return names, processes, nil
+}
+
+func (w *WorkSpace) ListTemplates(role string) ([]string, error) {
+	return w.listNames(w.Storage, w.TemplateDir(role), ".tmpl")
+}
+
+func (w *WorkSpace) ListSchemas() ([]string, error) {
+	return w.listNames(w.Storage, w.SchemaDir(), ".json")
+}
+
+func (w *WorkSpace) listNames(fsys fs.FS, dir, ext string) ([]string, error) {
+	dirEntries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || filepath.Ext(dirEntry.Name()) != ext {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(dirEntry.Name(), ext))
+	}
+	return names, nil
+}
+
+func (w *WorkSpace) MkDirAllIfNotExist(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err = os.MkdirAll(dir, w.DirPerm)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WorkSpace) writeFileIfNotExist(path string, content []byte) error {
+	if _, err := fs.Stat(w.Storage, path); errors.Is(err, fs.ErrNotExist) {
+		return w.WriteFile(path, content)
+	}
+	return nil
+}
+
+func (w *WorkSpace) WriteFile(path string, content []byte) error {
+	return w.Storage.WriteFile(path, content, w.FilePerm)
+}
+
+func (w *WorkSpace) ReadFile(path string) ([]byte, error) {
+	return fs.ReadFile(w.Storage, path)
+}
+
+// EditFile opens the file with open, such as an editor. A file that is not on disk or is encrypted is edited in a temporary file.
+func (w *WorkSpace) EditFile(name string, open func(path string) error) error {
+	if storage, ok := w.DiskStorage(); ok && !w.encrypts(name) {
+		p, err := storage.Path(name)
+		if err != nil {
+			return err
+		}
+		return open(p)
+	}
+
+	data, err := w.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	// The plain copy is kept in the cache directory, which only the user can read, until the editor exits.
+	tmp, err := os.CreateTemp(w.CacheDir, "afa-*"+path.Ext(name))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := open(tmp.Name()); err != nil {
+		return err
+	}
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	return w.WriteFile(name, edited)
+}
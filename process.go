@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// detachedSessionEnv names the session that the re-executed process runs in the background.
+const detachedSessionEnv = "AFA_DETACHED_SESSION"
+
+const (
+	detachTimeout = 10 * time.Second
+	killTimeout   = 5 * time.Second
+)
+
+// StartDetached runs the session in a background process, which is the same command re-executed without a terminal.
+func (ai *AIForAll) StartDetached(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	name := ai.WorkSpace.NewSessionName(time.Now())
+	logPath, err := ai.WorkSpace.DetachedLogPath(name)
+	if err != nil {
+		return err
+	}
+	log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, ai.WorkSpace.FilePerm)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", detachedSessionEnv, name))
+	cmd.Stdout = log
+	cmd.Stderr = log
+	setDetachedProcessAttr(cmd)
+	if ai.MessageStdin != "" {
+		// The standard input is already consumed, so pass it on to the background process.
+		stdinR, stdinW, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		defer stdinR.Close()
+		cmd.Stdin = stdinR
+		go func() {
+			stdinW.WriteString(ai.MessageStdin)
+			stdinW.Close()
+		}()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(detachTimeout)
+	for {
+		select {
+		case <-ticker.C:
+			if process, err := ai.WorkSpace.LoadProcess(name); err == nil && process.Alive() {
+				fmt.Fprintln(ai.Output, name)
+				return nil
+			}
+		case err := <-exited:
+			if err == nil {
+				// The session has already finished.
+				fmt.Fprintln(ai.Output, name)
+				return nil
+			}
+			output, _ := os.ReadFile(logPath)
+			return fmt.Errorf("Detached session exited. %v %s", err, strings.TrimSpace(string(output)))
+		case <-timeout:
+			cmd.Process.Kill()
+			return fmt.Errorf("Detached session did not start in %s.", detachTimeout)
+		}
+	}
+}
+
+func (ai *AIForAll) Ps() error {
+	names, processes, err := ai.WorkSpace.ListProcesses()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(ai.Output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPID\tSTARTED_AT\tDETACHED\tSOCKET")
+	for i, name := range names {
+		process := processes[i]
+		if !process.Alive() {
+			continue
+		}
+		socket := process.Socket
+		if socket == "" {
+			socket = "-"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%t\t%s\n",
+			name,
+			process.PID,
+			process.StartedAt.Format(time.RFC3339),
+			process.Detached,
+			socket,
+		)
+	}
+	return w.Flush()
+}
+
+func (ai *AIForAll) Kill() error {
+	processPath, err := ai.WorkSpace.ProcessPath(ai.SessionName)
+	if err != nil {
+		return err
+	}
+	process, err := ai.WorkSpace.LoadProcess(ai.SessionName)
+	if os.IsNotExist(err) {
+		return &NotFoundError{Name: ai.SessionName, Kind: "running session"}
+	}
+	if err != nil {
+		return err
+	}
+	if !process.Running() {
+		// The session has ended without cleaning up, so only the files are left.
+		if process.Socket != "" {
+			os.Remove(process.Socket)
+		}
+		return os.Remove(processPath)
+	}
+	p, err := os.FindProcess(process.PID)
+	if err != nil {
+		return err
+	}
+
+	// Ask the session to end so that it can clean up the socket, or kill it when it can not be signaled.
+	if err := p.Signal(syscall.SIGTERM); err == nil {
+		deadline := time.Now().Add(killTimeout)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(processPath); os.IsNotExist(err) {
+				return nil
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if err := p.Kill(); err != nil && err != os.ErrProcessDone {
+		return err
+	}
+	if process.Socket != "" {
+		os.Remove(process.Socket)
+	}
+	return os.Remove(processPath)
+}
@@ -0,0 +1,7 @@
+//go:build !unix && !windows
+
+package main
+
+import "os/exec"
+
+func setDetachedProcessAttr(cmd *exec.Cmd) {}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/monochromegane/afa/pkg/afa"
+)
+
+func staleSocket(t *testing.T, path string) {
+	t.Helper()
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+}
+
+func TestLockSession(t *testing.T) {
+	dir, err := os.MkdirTemp("", "afa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workSpace := afa.NewWorkSpace(dir, dir)
+	if err := os.MkdirAll(workSpace.SocketDir(), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// A session left by a crashed process is taken over.
+	socketPath, err := workSpace.SocketPath("crashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleSocket(t, socketPath)
+	crashed := &afa.SessionProcess{PID: os.Getpid(), Socket: socketPath, StartedAt: time.Now().Add(-time.Hour)}
+	if err := workSpace.LockSession("crashed", crashed); err != nil {
+		t.Fatal(err)
+	}
+	if err := workSpace.LockSession("crashed", afa.NewSessionProcess(socketPath, false)); err != nil {
+		t.Fatalf("LockSession should remove the stale lock: %v", err)
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("LockSession should remove the stale socket")
+	}
+
+	// A session served by a live process is refused.
+	socketPath, err = workSpace.SocketPath("live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if err := workSpace.LockSession("live", afa.NewSessionProcess(socketPath, false)); err != nil {
+		t.Fatal(err)
+	}
+	err = workSpace.LockSession("live", afa.NewSessionProcess(socketPath, false))
+	var inUse *afa.SessionInUseError
+	if !errors.As(err, &inUse) || inUse.PID != os.Getpid() {
+		t.Errorf("LockSession should return SessionInUseError, got %v", err)
+	}
+}
+
+func TestNewServerRemovesStaleSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "afa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.sock")
+	staleSocket(t, path)
+	server, err := NewServer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Listen(); err != nil {
+		t.Fatalf("Listen should succeed after removing the stale socket: %v", err)
+	}
+	defer server.Disconnect()
+
+	if _, err := NewServer(path); err == nil {
+		t.Errorf("NewServer should return error when the socket is in use")
+	}
+}
+
+func TestKillRemovesStaleProcess(t *testing.T) {
+	dir, err := os.MkdirTemp("", "afa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ai := &AIForAll{
+		WorkSpace:   afa.NewWorkSpace(dir, dir),
+		Option:      afa.NewOption(),
+		SessionName: "stale",
+	}
+	if err := os.MkdirAll(ai.WorkSpace.SocketDir(), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another program has taken the PID of the session that has gone.
+	other := exec.Command("sleep", "10")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Process.Kill()
+	stale := &afa.SessionProcess{PID: other.Process.Pid, StartedAt: time.Now().Add(-time.Hour)}
+	if err := ai.WorkSpace.LockSession("stale", stale); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ai.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ai.WorkSpace.LoadProcess("stale"); !os.IsNotExist(err) {
+		t.Errorf("Kill should remove the stale process file, got %v", err)
+	}
+	if err := other.Process.Signal(syscall.Signal(0)); err != nil {
+		t.Errorf("Kill should not signal another program: %v", err)
+	}
+}
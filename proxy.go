@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/monochromegane/afa/internal/payload"
+	"github.com/monochromegane/afa/pkg/afa"
+)
+
+// The OpenAI compatible endpoint lets tools that only speak the OpenAI API use afa as a gateway.
+// afa's system template is applied when the request has no system message,
+// and each exchange is logged as a session with its token usage.
+
+type ChatCompletionRequest struct {
+	Model         string                 `json:"model"`
+	Messages      []*ChatCompletionInput `json:"messages"`
+	Stream        bool                   `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	ResponseFormat *struct {
+		Type       string `json:"type"`
+		JsonSchema *struct {
+			Name   string           `json:"name"`
+			Schema *json.RawMessage `json:"schema"`
+		} `json:"json_schema,omitempty"`
+	} `json:"response_format,omitempty"`
+}
+
+type ChatCompletionInput struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type ChatCompletionOutput struct {
+	ID      string                  `json:"id"`
+	Object  string                  `json:"object"`
+	Created int64                   `json:"created"`
+	Model   string                  `json:"model"`
+	Choices []*ChatCompletionChoice `json:"choices"`
+	Usage   *payload.Usage          `json:"usage,omitempty"`
+}
+
+type ChatCompletionChoice struct {
+	Index        int              `json:"index"`
+	Message      *payload.Message `json:"message,omitempty"`
+	Delta        *payload.Message `json:"delta,omitempty"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+type ModelList struct {
+	Object string         `json:"object"`
+	Data   []*ModelObject `json:"data"`
+}
+
+type ModelObject struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// text returns the content which is either a string or an array of content parts.
+func (m *ChatCompletionInput) text() (string, error) {
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("Unsupported content of %s message.", m.Role)
+	}
+	texts := []string{}
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("Unsupported content part %q.", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func (s *APIServer) listModels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &ModelList{
+		Object: "list",
+		Data: []*ModelObject{
+			{ID: s.Option.Chat.Model, Object: "model", OwnedBy: cmdName},
+		},
+	})
+}
+
+func (s *APIServer) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	var request ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	history, err := s.newProxyHistory(&request, r.Header.Get("X-Afa-System-Template"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.redactProxyHistory(history); err != nil {
+		writeJSONError(w, statusFromError(err), err)
+		return
+	}
+
+	s.mu.Lock()
+	name, sessionPath := s.newSessionName(time.Now())
+	err = s.WorkSpace.SetupSession(sessionPath, history.Model, "")
+	s.mu.Unlock()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	output := &ChatCompletionOutput{
+		ID:      fmt.Sprintf("chatcmpl-%s-%s", cmdName, name),
+		Created: time.Now().Unix(),
+		Model:   history.Model,
+	}
+	client, err := afa.NewClient(history.Model)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx := context.WithValue(r.Context(), "openai-api-key", s.Secret.OpenAI.ApiKey)
+	hooks := afa.NewHooks(s.Option.Hooks, name)
+	includeUsage := request.StreamOptions != nil && request.StreamOptions.IncludeUsage
+
+	var response *payload.Response
+	chatRequest, _, err := hooks.Run(ctx, afa.HookPreRequest, history.Request, nil)
+	switch {
+	case err != nil:
+		writeJSONError(w, statusFromError(err), err)
+	case request.Stream && !hooks.Has(afa.HookPostResponse):
+		response, err = s.streamChatCompletion(ctx, w, client, chatRequest, output, includeUsage)
+	default:
+		// The response is sent after the post_response hooks, which may change or reject it.
+		response, err = client.ChatCompletion(chatRequest, ctx)
+		if err == nil {
+			_, response, err = hooks.Run(ctx, afa.HookPostResponse, chatRequest, response)
+		}
+		if err != nil {
+			writeJSONError(w, statusFromError(err), err)
+			break
+		}
+		if request.Stream {
+			err = writeChatCompletion(w, output, response, includeUsage)
+			break
+		}
+		stop := "stop"
+		output.Object = "chat.completion"
+		output.Choices = []*ChatCompletionChoice{{Message: response.Message, FinishReason: &stop}}
+		output.Usage = response.Usage
+		writeJSON(w, http.StatusOK, output)
+	}
+	if err != nil {
+		s.WorkSpace.RemoveSession(name)
+		return
+	}
+
+	history.AddMessage(response.Message.Role, response.Message.Content)
+	history.AddUsage(response.Usage)
+	// The response has already been sent, so a failed save can only be logged.
+	if err := s.saveHistory(context.WithoutCancel(ctx), name, hooks, history); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save session %s. %v\n", name, err)
+	}
+}
+
+// chatCompletionStream writes a completion as chunks of server-sent events.
+type chatCompletionStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	output  *ChatCompletionOutput
+}
+
+func newChatCompletionStream(w http.ResponseWriter, output *ChatCompletionOutput) (*chatCompletionStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err := fmt.Errorf("Streaming is not supported.")
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return nil, err
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	output.Object = "chat.completion.chunk"
+	return &chatCompletionStream{w: w, flusher: flusher, output: output}, nil
+}
+
+func (s *chatCompletionStream) write(output *ChatCompletionOutput) error {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *chatCompletionStream) delta(message *payload.Message) error {
+	chunk := *s.output
+	chunk.Choices = []*ChatCompletionChoice{{Delta: message}}
+	return s.write(&chunk)
+}
+
+func (s *chatCompletionStream) finish(usage *payload.Usage, includeUsage bool) error {
+	stop := "stop"
+	chunk := *s.output
+	chunk.Choices = []*ChatCompletionChoice{{Delta: &payload.Message{}, FinishReason: &stop}}
+	if err := s.write(&chunk); err != nil {
+		return err
+	}
+	if includeUsage && usage != nil {
+		chunk := *s.output
+		chunk.Choices = []*ChatCompletionChoice{}
+		chunk.Usage = usage
+		if err := s.write(&chunk); err != nil {
+			return err
+		}
+	}
+	fmt.Fprint(s.w, "data: [DONE]\n\n")
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *APIServer) streamChatCompletion(ctx context.Context, w http.ResponseWriter, client afa.Client, request *payload.Request, output *ChatCompletionOutput, includeUsage bool) (*payload.Response, error) {
+	stream, err := newChatCompletionStream(w, output)
+	if err != nil {
+		return nil, err
+	}
+
+	started := false
+	message := &payload.Message{Role: "assistant"}
+	var usage *payload.Usage
+	err = client.ChatCompletionStream(request, ctx, func(response *payload.Response) error {
+		started = true
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+		if response.Message.Role == "" && response.Message.Content == "" {
+			return nil
+		}
+		if response.Message.Role != "" {
+			message.Role = response.Message.Role
+		}
+		message.Content += response.Message.Content
+		return stream.delta(response.Message)
+	})
+	if err != nil {
+		if !started {
+			writeJSONError(w, statusFromError(err), err)
+		} else {
+			data, _ := json.Marshal(&ErrorReport{Error: &ErrorDetail{Type: "api_error", Message: err.Error()}})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		return nil, err
+	}
+	if err := stream.finish(usage, includeUsage); err != nil {
+		return nil, err
+	}
+	return &payload.Response{Message: message, Usage: usage}, nil
+}
+
+// writeChatCompletion writes the whole response as a stream of one chunk.
+func writeChatCompletion(w http.ResponseWriter, output *ChatCompletionOutput, response *payload.Response, includeUsage bool) error {
+	stream, err := newChatCompletionStream(w, output)
+	if err != nil {
+		return err
+	}
+	if err := stream.delta(response.Message); err != nil {
+		return err
+	}
+	return stream.finish(response.Usage, includeUsage)
+}
+
+// redactProxyHistory applies the redaction to the user messages, as the sessions do to the user prompts.
+func (s *APIServer) redactProxyHistory(history *afa.History) error {
+	redactor, err := afa.NewRedactor(s.Option.Redact, os.Stderr)
+	if err != nil {
+		return err
+	}
+	for _, message := range history.Request.Messages {
+		if message.Role != "user" {
+			continue
+		}
+		if message.Content, err = redactor.Apply("user message", message.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *APIServer) newProxyHistory(request *ChatCompletionRequest, systemTemplate string) (*afa.History, error) {
+	model := request.Model
+	if model == "" {
+		model = s.Option.Chat.Model
+	}
+	if len(request.Messages) == 0 {
+		return nil, fmt.Errorf("Messages are empty.")
+	}
+
+	history := afa.NewHistory(model, "", nil)
+	if format := request.ResponseFormat; format != nil && format.Type == "json_schema" && format.JsonSchema != nil {
+		history.JsonSchema = &payload.JsonSchema{
+			Name:   format.JsonSchema.Name,
+			Schema: format.JsonSchema.Schema,
+		}
+	}
+
+	hasSystem := false
+	for _, message := range request.Messages {
+		if message.Role == "system" || message.Role == "developer" {
+			hasSystem = true
+		}
+	}
+	if !hasSystem {
+		if systemTemplate == "" {
+			systemTemplate = s.Option.Chat.SystemPromptTemplate
+		}
+		systemTemplatePath, err := s.WorkSpace.TemplatePath("system", systemTemplate)
+		if err != nil {
+			return nil, err
+		}
+		systemPrompt, err := afa.NewPrompt(s.WorkSpace.Storage, systemTemplatePath, "", "", "", []string{})
+		if err != nil {
+			return nil, err
+		}
+		history.AddMessage("system", systemPrompt)
+	}
+
+	for _, message := range request.Messages {
+		content, err := message.text()
+		if err != nil {
+			return nil, err
+		}
+		history.AddMessage(message.Role, content)
+	}
+	return history, nil
+}
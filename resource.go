@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/monochromegane/afa/internal/jsonschema"
+	"github.com/monochromegane/afa/pkg/afa"
+)
+
+var templateRoles = []string{"system", "user"}
+
+type resource struct {
+	kind     string
+	list     func() ([]string, error)
+	path     func(name string) (string, error)
+	skeleton func(name string) []byte
+	generate func() ([]byte, error)
+	validate func(path string) error
+}
+
+func (ai *AIForAll) Templates() error {
+	return ai.manage(&resource{
+		kind: "template",
+		list: func() ([]string, error) {
+			refs := []string{}
+			for _, role := range templateRoles {
+				names, err := ai.WorkSpace.ListTemplates(role)
+				if err != nil {
+					return nil, err
+				}
+				for _, name := range names {
+					refs = append(refs, fmt.Sprintf("%s/%s", role, name))
+				}
+			}
+			return refs, nil
+		},
+		path: func(ref string) (string, error) {
+			role, name, err := parseTemplateRef(ref)
+			if err != nil {
+				return "", err
+			}
+			return ai.WorkSpace.TemplatePath(role, name)
+		},
+		skeleton: func(ref string) []byte {
+			if strings.HasPrefix(ref, "user/") {
+				return []byte("{{ .Message }}\n")
+			}
+			return []byte{}
+		},
+		validate: func(path string) error {
+			return afa.ValidatePromptTemplate(ai.WorkSpace.Storage, path)
+		},
+	})
+}
+
+func (ai *AIForAll) Schemas() error {
+	return ai.manage(&resource{
+		kind: "schema",
+		list: ai.WorkSpace.ListSchemas,
+		path: func(name string) (string, error) {
+			if name == "" {
+				return "", fmt.Errorf("Schema name is empty.")
+			}
+			return ai.WorkSpace.SchemaPath(name)
+		},
+		skeleton: func(name string) []byte {
+			return []byte("{\n  \"type\": \"object\",\n  \"properties\": {},\n  \"additionalProperties\": false,\n  \"required\": []\n}\n")
+		},
+		generate: ai.generateSchema,
+		validate: func(path string) error {
+			data, err := ai.WorkSpace.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return afa.ValidateStrictSchema(data)
+		},
+	})
+}
+
+func (ai *AIForAll) generateSchema() ([]byte, error) {
+	switch {
+	case ai.SchemaExample != "" && ai.SchemaSpec != "":
+		return nil, fmt.Errorf("Specify either an example or a spec, not both.")
+	case ai.SchemaExample != "":
+		var example []byte
+		var err error
+		if ai.SchemaExample == "-" {
+			example, err = io.ReadAll(ai.Input)
+		} else {
+			example, err = os.ReadFile(ai.SchemaExample)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return jsonschema.FromExample(example)
+	case ai.SchemaSpec != "":
+		return jsonschema.FromSpec(ai.SchemaSpec)
+	}
+	return nil, nil
+}
+
+func (ai *AIForAll) manage(r *resource) error {
+	switch ai.Action {
+	case "", "list":
+		names, err := r.list()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Fprintln(ai.Output, name)
+		}
+		return nil
+	case "show":
+		if len(ai.Args) != 1 {
+			return wrongNumberOfArgsError(ai.Action, "NAME")
+		}
+		path, err := ai.existingPath(r, ai.Args[0])
+		if err != nil {
+			return err
+		}
+		data, err := ai.WorkSpace.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		_, err = ai.Output.Write(data)
+		return err
+	case "new", "create":
+		if len(ai.Args) != 1 {
+			return wrongNumberOfArgsError(ai.Action, "NAME")
+		}
+		path, err := r.path(ai.Args[0])
+		if err != nil {
+			return err
+		}
+		if ai.WorkSpace.Exists(path) {
+			return fmt.Errorf("%s: %s already exists", ai.WorkSpace.DisplayPath(path), r.kind)
+		}
+		if r.generate != nil {
+			content, err := r.generate()
+			if err != nil {
+				return err
+			}
+			if content != nil {
+				if err := ai.WorkSpace.WriteFile(path, content); err != nil {
+					return err
+				}
+				return r.validate(path)
+			}
+		}
+		if err := ai.WorkSpace.WriteFile(path, r.skeleton(ai.Args[0])); err != nil {
+			return err
+		}
+		if err := ai.WorkSpace.EditFile(path, openEditor); err != nil {
+			return err
+		}
+		return r.validate(path)
+	case "copy":
+		if len(ai.Args) != 2 {
+			return wrongNumberOfArgsError(ai.Action, "SRC DST")
+		}
+		src, err := ai.existingPath(r, ai.Args[0])
+		if err != nil {
+			return err
+		}
+		dst, err := r.path(ai.Args[1])
+		if err != nil {
+			return err
+		}
+		if ai.WorkSpace.Exists(dst) {
+			return fmt.Errorf("%s: %s already exists", ai.WorkSpace.DisplayPath(dst), r.kind)
+		}
+		data, err := ai.WorkSpace.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		return ai.WorkSpace.WriteFile(dst, data)
+	case "delete":
+		if len(ai.Args) == 0 {
+			return wrongNumberOfArgsError(ai.Action, "NAME...")
+		}
+		for _, name := range ai.Args {
+			path, err := ai.existingPath(r, name)
+			if err != nil {
+				return err
+			}
+			if err := ai.WorkSpace.Storage.Remove(path); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "validate":
+		names := ai.Args
+		if len(names) == 0 {
+			var err error
+			names, err = r.list()
+			if err != nil {
+				return err
+			}
+		}
+		invalid := 0
+		for _, name := range names {
+			path, err := ai.existingPath(r, name)
+			if err == nil {
+				err = r.validate(path)
+			}
+			if err != nil {
+				invalid++
+				fmt.Fprintf(ai.Output, "%s\tNG\n", name)
+				for _, line := range strings.Split(err.Error(), "\n") {
+					fmt.Fprintf(ai.Output, "\t%s\n", line)
+				}
+				continue
+			}
+			fmt.Fprintf(ai.Output, "%s\tOK\n", name)
+		}
+		if invalid > 0 {
+			return fmt.Errorf("%d of %d %ss are invalid.", invalid, len(names), r.kind)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown action %q. Please provide one of the following actions: list, show, new, copy, delete, validate.", ai.Action)
+	}
+}
+
+func (ai *AIForAll) existingPath(r *resource, name string) (string, error) {
+	path, err := r.path(name)
+	if err != nil {
+		return "", err
+	}
+	if !ai.WorkSpace.Exists(path) {
+		return "", &NotFoundError{Name: ai.WorkSpace.DisplayPath(path), Kind: r.kind}
+	}
+	return path, nil
+}
+
+func parseTemplateRef(ref string) (string, string, error) {
+	role, name, found := strings.Cut(ref, "/")
+	if !found || name == "" {
+		return "", "", fmt.Errorf("%q: template must be specified as ROLE/NAME (e.g. user/default)", ref)
+	}
+	for _, r := range templateRoles {
+		if role == r {
+			return role, name, nil
+		}
+	}
+	return "", "", fmt.Errorf("%q: role must be one of %s", ref, strings.Join(templateRoles, ", "))
+}
+
+func openEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func wrongNumberOfArgsError(action, usage string) error {
+	return &UsageError{fmt.Errorf("Wrong number of arguments. Usage: %s %s", action, usage)}
+}
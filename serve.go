@@ -0,0 +1,602 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/monochromegane/afa/internal/llm"
+	"github.com/monochromegane/afa/internal/payload"
+	"github.com/monochromegane/afa/internal/protocol"
+	"github.com/monochromegane/afa/pkg/afa"
+)
+
+// APIServer exposes sessions, templates and schemas over HTTP,
+// so that editor plugins and dashboards can talk to one long-lived process.
+type APIServer struct {
+	WorkSpace *afa.WorkSpace
+	Option    *afa.Option
+	Secret    *afa.Secret
+	// Token is the bearer token required on every request.
+	Token string
+
+	mu sync.Mutex
+	// Requests to a session are serialized by one of the locks, chosen by the hash of its name.
+	locks [sessionLocks]sync.Mutex
+}
+
+const sessionLocks = 64
+
+type SessionSummary struct {
+	Name        string `json:"name"`
+	Model       string `json:"model"`
+	Schema      string `json:"schema,omitempty"`
+	FirstPrompt string `json:"first_prompt"`
+}
+
+type NewSessionRequest struct {
+	Model                string `json:"model"`
+	Schema               string `json:"schema"`
+	SystemPromptTemplate string `json:"system_prompt_template"`
+}
+
+type MessageRequest struct {
+	Message            string         `json:"message"`
+	Files              []*MessageFile `json:"files"`
+	UserPromptTemplate string         `json:"user_prompt_template"`
+	Stream             bool           `json:"stream"`
+}
+
+// MessageFile is a file of the prompt. The server does not read local files for clients.
+type MessageFile struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+type MessageResponse struct {
+	Role    string         `json:"role"`
+	Content string         `json:"content"`
+	Usage   *payload.Usage `json:"usage,omitempty"`
+}
+
+type TemplateSummary struct {
+	Role string `json:"role"`
+	Name string `json:"name"`
+}
+
+func NewAPIServer(workSpace *afa.WorkSpace, option *afa.Option, secret *afa.Secret) *APIServer {
+	return &APIServer{
+		WorkSpace: workSpace,
+		Option:    option,
+		Secret:    secret,
+		Token:     option.Serve.Token,
+	}
+}
+
+func (s *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/sessions", s.listSessions)
+	mux.HandleFunc("POST /api/sessions", s.newSession)
+	mux.HandleFunc("GET /api/sessions/{name}", s.getSession)
+	mux.HandleFunc("DELETE /api/sessions/{name}", s.deleteSession)
+	mux.HandleFunc("POST /api/sessions/{name}/messages", s.postMessage)
+	mux.HandleFunc("GET /api/templates", s.listTemplates)
+	mux.HandleFunc("GET /api/templates/{role}/{name}", s.getTemplate)
+	mux.HandleFunc("GET /api/schemas", s.listSchemas)
+	mux.HandleFunc("GET /api/schemas/{name}", s.getSchema)
+	mux.HandleFunc("GET /v1/models", s.listModels)
+	mux.HandleFunc("POST /v1/chat/completions", s.chatCompletions)
+	return s.authorize(mux)
+}
+
+// authorize requires the bearer token, and refuses requests from pages of other origins.
+func (s *APIServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, r.Host) {
+			writeJSONError(w, http.StatusForbidden, fmt.Errorf("Cross-origin requests are not allowed."))
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("Invalid token."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == host
+}
+
+func (s *APIServer) listSessions(w http.ResponseWriter, r *http.Request) {
+	count := s.Option.List.Count
+	if n := r.URL.Query().Get("n"); n != "" {
+		var err error
+		if count, err = strconv.Atoi(n); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	names, histories, err := s.WorkSpace.ListSessions(count, r.URL.Query().Get("order") == "modified")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	summaries := []*SessionSummary{}
+	for i, name := range names {
+		summaries = append(summaries, newSessionSummary(name, histories[i]))
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *APIServer) newSession(w http.ResponseWriter, r *http.Request) {
+	request := &NewSessionRequest{
+		Model:                s.Option.Chat.Model,
+		Schema:               s.Option.Chat.Schema,
+		SystemPromptTemplate: s.Option.Chat.SystemPromptTemplate,
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	systemTemplatePath, err := s.WorkSpace.TemplatePath("system", request.SystemPromptTemplate)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.Lock()
+	name, sessionPath := s.newSessionName(time.Now())
+	err = s.WorkSpace.SetupSession(sessionPath, request.Model, request.Schema)
+	s.mu.Unlock()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	history, err := s.WorkSpace.LoadHistory(sessionPath)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	systemPrompt, err := afa.NewPrompt(s.WorkSpace.Storage, systemTemplatePath, "", "", "", []string{})
+	if err != nil {
+		s.WorkSpace.RemoveSession(name)
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	history.AddMessage("system", systemPrompt)
+	if err := s.WorkSpace.SaveHistory(name, history); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newSessionSummary(name, history))
+}
+
+func (s *APIServer) getSession(w http.ResponseWriter, r *http.Request) {
+	history, ok := s.loadHistory(w, r.PathValue("name"))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (s *APIServer) deleteSession(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	lock := s.lock(name)
+	defer lock.Unlock()
+
+	if _, ok := s.loadHistory(w, name); !ok {
+		return
+	}
+	if err := s.WorkSpace.RemoveSession(name); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *APIServer) postMessage(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	request := &MessageRequest{
+		UserPromptTemplate: s.Option.Chat.UserPromptTemplate,
+		Stream:             strings.Contains(r.Header.Get("Accept"), "text/event-stream"),
+	}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.Message == "" && len(request.Files) == 0 {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("Message is empty."))
+		return
+	}
+
+	// Requests to the same session are serialized to keep its history consistent.
+	lock := s.lock(name)
+	defer lock.Unlock()
+
+	history, ok := s.loadHistory(w, name)
+	if !ok {
+		return
+	}
+	systemPromptTemplate, err := s.WorkSpace.TemplatePath("system", s.Option.Chat.SystemPromptTemplate)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	userPromptTemplate, err := s.WorkSpace.TemplatePath("user", request.UserPromptTemplate)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	session, err := afa.NewSession(s.Secret, history, s.WorkSpace.Storage, afa.SessionOptions{
+		SystemPromptTemplate: systemPromptTemplate,
+		UserPromptTemplate:   userPromptTemplate,
+		Stream:               request.Stream,
+		RepairRetries:        s.Option.Chat.RepairRetries,
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	session.Hooks = afa.NewHooks(s.Option.Hooks, name)
+	if session.Redactor, err = afa.NewRedactor(s.Option.Redact, os.Stderr); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var output afa.MessageWriter
+	var recorder *MessageRecorder
+	if request.Stream {
+		sse, err := NewSSEMessageWriter(w)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		output = sse
+	} else {
+		recorder = &MessageRecorder{}
+		output = recorder
+	}
+
+	names, contents := []string{}, map[string]string{}
+	for _, file := range request.Files {
+		names = append(names, file.Name)
+		contents[file.Name] = file.Content
+	}
+	session.ReadFile = func(name string) ([]byte, error) {
+		return []byte(contents[name]), nil
+	}
+
+	err = session.Start(request.Message, "", names, r.Context(), strings.NewReader(""), output)
+	if err != nil {
+		if request.Stream {
+			output.Error(err)
+			return
+		}
+		writeJSONError(w, statusFromError(err), err)
+		return
+	}
+	if err := s.saveHistory(r.Context(), name, session.Hooks, session.History); err != nil {
+		output.Error(err)
+		if !request.Stream {
+			writeJSONError(w, statusFromError(err), err)
+		}
+		return
+	}
+	if request.Stream {
+		output.Disconnect()
+		return
+	}
+	writeJSON(w, http.StatusOK, &MessageResponse{
+		Role:    "assistant",
+		Content: session.History.LastAssistantMessage(),
+		Usage:   recorder.usage,
+	})
+}
+
+func (s *APIServer) listTemplates(w http.ResponseWriter, r *http.Request) {
+	summaries := []*TemplateSummary{}
+	for _, role := range templateRoles {
+		names, err := s.WorkSpace.ListTemplates(role)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, name := range names {
+			summaries = append(summaries, &TemplateSummary{Role: role, Name: name})
+		}
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *APIServer) getTemplate(w http.ResponseWriter, r *http.Request) {
+	role, name, err := parseTemplateRef(r.PathValue("role") + "/" + r.PathValue("name"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	templatePath, err := s.WorkSpace.TemplatePath(role, name)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	data, err := s.WorkSpace.ReadFile(templatePath)
+	if err != nil {
+		writeJSONError(w, statusFromError(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+func (s *APIServer) listSchemas(w http.ResponseWriter, r *http.Request) {
+	names, err := s.WorkSpace.ListSchemas()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (s *APIServer) getSchema(w http.ResponseWriter, r *http.Request) {
+	schema, err := s.WorkSpace.LoadSchema(r.PathValue("name"))
+	if err != nil {
+		writeJSONError(w, statusFromError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, schema)
+}
+
+func (s *APIServer) loadHistory(w http.ResponseWriter, name string) (*afa.History, bool) {
+	sessionPath, err := s.WorkSpace.SessionPath(name)
+	if err != nil {
+		writeJSONError(w, statusFromError(err), err)
+		return nil, false
+	}
+	history, err := s.WorkSpace.LoadHistory(sessionPath)
+	if err != nil {
+		writeJSONError(w, statusFromError(err), err)
+		return nil, false
+	}
+	return history, true
+}
+
+func (s *APIServer) lock(name string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	lock := &s.locks[hash.Sum32()%sessionLocks]
+	lock.Lock()
+	return lock
+}
+
+// newSessionName returns the name of a new session and its path.
+func (s *APIServer) newSessionName(startedAt time.Time) (string, string) {
+	name := s.WorkSpace.NewSessionName(startedAt)
+	// Names from the time are always valid.
+	sessionPath, _ := s.WorkSpace.SessionPath(name)
+	return name, sessionPath
+}
+
+func newSessionSummary(name string, history *afa.History) *SessionSummary {
+	summary := &SessionSummary{
+		Name:        name,
+		Model:       history.Model,
+		FirstPrompt: history.FirstUserPrompt(),
+	}
+	if history.JsonSchema != nil {
+		summary.Schema = history.JsonSchema.Name
+	}
+	return summary
+}
+
+const sseEventDone = "done"
+
+// SSEMessageWriter streams the session as server-sent events. Each event has the same JSON
+// representation as the viewer protocol. (e.g. "event: chunk\ndata: {"type":"chunk","content":"..."}")
+type SSEMessageWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func NewSSEMessageWriter(w http.ResponseWriter) (*SSEMessageWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("Streaming is not supported.")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &SSEMessageWriter{w: w, flusher: flusher}, nil
+}
+
+func (w *SSEMessageWriter) Write(p []byte) (int, error) {
+	if err := w.writeEvent(&protocol.Event{Type: protocol.EventChunk, Content: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *SSEMessageWriter) Disconnect() error {
+	return w.writeEvent(&protocol.Event{Type: sseEventDone})
+}
+
+func (w *SSEMessageWriter) Prompt() error {
+	return nil
+}
+
+func (w *SSEMessageWriter) Error(err error) error {
+	return w.writeEvent(&protocol.Event{Type: protocol.EventError, Message: err.Error()})
+}
+
+func (w *SSEMessageWriter) MessageStart(role string) error {
+	return w.writeEvent(&protocol.Event{Type: protocol.EventMessageStart, Role: role})
+}
+
+func (w *SSEMessageWriter) MessageEnd(role string) error {
+	return w.writeEvent(&protocol.Event{Type: protocol.EventMessageEnd, Role: role})
+}
+
+func (w *SSEMessageWriter) Usage(usage *payload.Usage) error {
+	return w.writeEvent(&protocol.Event{Type: protocol.EventUsage, Usage: usage})
+}
+
+func (w *SSEMessageWriter) writeEvent(event *protocol.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w.w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	w.flusher.Flush()
+	return nil
+}
+
+// MessageRecorder discards the printed response and keeps the usage.
+type MessageRecorder struct {
+	afa.DefaultMessageWriter
+	usage *payload.Usage
+}
+
+func (r *MessageRecorder) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (r *MessageRecorder) Prompt() error {
+	return nil
+}
+
+func (r *MessageRecorder) Usage(usage *payload.Usage) error {
+	r.usage = usage
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	detail, _ := errorDetail(err)
+	if detail.Type == "error" {
+		detail.Type = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	writeJSON(w, status, &ErrorReport{Error: detail})
+}
+
+func (s *APIServer) saveHistory(ctx context.Context, name string, hooks *afa.Hooks, history *afa.History) error {
+	request, _, err := hooks.Run(ctx, afa.HookSessionSave, history.Request, nil)
+	if err != nil {
+		return err
+	}
+	history.Request = request
+	return s.WorkSpace.SaveHistory(name, history)
+}
+
+func statusFromError(err error) int {
+	var lerr *llm.Error
+	if errors.As(err, &lerr) {
+		switch lerr.Kind {
+		case llm.KindRateLimit, llm.KindContextLength, llm.KindInvalidRequest:
+			return lerr.StatusCode
+		default:
+			// The provider failed on behalf of the client.
+			return http.StatusBadGateway
+		}
+	}
+	var verr *afa.ResponseValidationError
+	if errors.As(err, &verr) {
+		return http.StatusBadGateway
+	}
+	var herr *afa.HookRejectedError
+	if errors.As(err, &herr) {
+		return http.StatusForbidden
+	}
+	var serr *afa.SecretsFoundError
+	if errors.As(err, &serr) {
+		return http.StatusForbidden
+	}
+	var nerr *afa.InvalidNameError
+	if errors.As(err, &nerr) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func listen(address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+func (ai *AIForAll) Serve() error {
+	secret, err := ai.WorkSpace.LoadSecret()
+	if err != nil {
+		return err
+	}
+	listener, err := listen(ai.Option.Serve.Listen)
+	if err != nil {
+		return err
+	}
+
+	apiServer := NewAPIServer(ai.WorkSpace, ai.Option, secret)
+	if apiServer.Token == "" {
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			return err
+		}
+		apiServer.Token = hex.EncodeToString(token)
+	}
+	server := &http.Server{
+		Handler: apiServer.Handler(),
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", listener.Addr())
+	if ai.Option.Serve.Token == "" {
+		fmt.Fprintf(os.Stderr, "Token: %s\n", apiServer.Token)
+	}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// Serve returns as soon as the shutdown begins, so wait for the requests in flight, such as saving sessions.
+	<-shutdown
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/monochromegane/afa/internal/llm/llmtest"
+	"github.com/monochromegane/afa/pkg/afa"
+)
+
+const testAPIToken = "test-token"
+
+func newTestAPIServer(t *testing.T) (*APIServer, *httptest.Server) {
+	t.Helper()
+	workSpace := afa.NewWorkSpaceWithStorage(afa.NewMemoryStorage(), t.TempDir())
+	if err := workSpace.Setup(afa.NewOption(), afa.NewSecret("test-key")); err != nil {
+		t.Fatal(err)
+	}
+	option := afa.NewOption()
+	option.Serve.Token = testAPIToken
+	secret, err := workSpace.LoadSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiServer := NewAPIServer(workSpace, option, secret)
+	server := httptest.NewServer(apiServer.Handler())
+	t.Cleanup(server.Close)
+	return apiServer, server
+}
+
+// newTestProvider registers the provider of the fake server for models prefixed by "llmtest/".
+func newTestProvider(t *testing.T, responses ...*llmtest.Response) *llmtest.Server {
+	t.Helper()
+	server := llmtest.NewServer(responses...)
+	t.Cleanup(server.Close)
+	afa.RegisterProvider("llmtest", func(model string) (afa.Client, error) {
+		return server.Client(), nil
+	})
+	return server
+}
+
+func doAPIRequest(t *testing.T, method, url, body string, header map[string]string) (int, []byte) {
+	t.Helper()
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+testAPIToken)
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, data
+}
+
+func TestAPIServerRequiresToken(t *testing.T) {
+	_, server := newTestAPIServer(t)
+
+	for _, tt := range []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"valid token", nil, http.StatusOK},
+		{"no token", map[string]string{"Authorization": ""}, http.StatusUnauthorized},
+		{"wrong token", map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized},
+		{"same origin", map[string]string{"Origin": server.URL}, http.StatusOK},
+		{"other origin", map[string]string{"Origin": "http://example.com"}, http.StatusForbidden},
+	} {
+		for _, path := range []string{"/api/sessions", "/v1/models"} {
+			if status, _ := doAPIRequest(t, http.MethodGet, server.URL+path, "", tt.header); status != tt.want {
+				t.Errorf("%s: GET %s = %d, want %d", tt.name, path, status, tt.want)
+			}
+		}
+	}
+}
+
+func TestAPIServerSessions(t *testing.T) {
+	provider := newTestProvider(t, &llmtest.Response{Content: "Hello"})
+	_, server := newTestAPIServer(t)
+
+	status, data := doAPIRequest(t, http.MethodPost, server.URL+"/api/sessions", `{"model":"llmtest/model"}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("POST /api/sessions = %d %s", status, data)
+	}
+	var summary SessionSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are sent with their contents, and local files are not read.
+	body := `{"message":"Review this","files":[{"name":"/etc/passwd","content":"package main\n"}]}`
+	status, data = doAPIRequest(t, http.MethodPost, server.URL+"/api/sessions/"+summary.Name+"/messages", body, nil)
+	if status != http.StatusOK {
+		t.Fatalf("POST messages = %d %s", status, data)
+	}
+	var message MessageResponse
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Content != "Hello" {
+		t.Errorf("POST messages content = %q, want Hello", message.Content)
+	}
+	requests := provider.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("provider received %d requests, want 1", len(requests))
+	}
+	prompt := requests[0].Messages[len(requests[0].Messages)-1].Content
+	if !strings.Contains(prompt, "package main") || strings.Contains(prompt, "root:") {
+		t.Errorf("user prompt = %q, want the content of the request", prompt)
+	}
+
+	status, data = doAPIRequest(t, http.MethodGet, server.URL+"/api/sessions", "", nil)
+	if status != http.StatusOK || !strings.Contains(string(data), summary.Name) {
+		t.Errorf("GET /api/sessions = %d %s", status, data)
+	}
+	if status, _ := doAPIRequest(t, http.MethodDelete, server.URL+"/api/sessions/"+summary.Name, "", nil); status != http.StatusNoContent {
+		t.Errorf("DELETE session = %d, want %d", status, http.StatusNoContent)
+	}
+	if status, _ := doAPIRequest(t, http.MethodGet, server.URL+"/api/sessions/"+summary.Name, "", nil); status != http.StatusNotFound {
+		t.Errorf("GET deleted session = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestAPIServerRejectsNamesOutOfDirectory(t *testing.T) {
+	apiServer, server := newTestAPIServer(t)
+
+	for _, tt := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/api/schemas/..%2Fsecret"},
+		{http.MethodGet, "/api/sessions/..%2F..%2Fconfig%2Fsecret"},
+		{http.MethodDelete, "/api/sessions/..%2F..%2Fconfig%2Fsecret"},
+		{http.MethodGet, "/api/templates/user/..%2F..%2Fsecret"},
+		{http.MethodPost, "/api/sessions/..%2F..%2Fconfig%2Fsecret/messages"},
+	} {
+		if status, _ := doAPIRequest(t, tt.method, server.URL+tt.path, `{"message":"hi"}`, nil); status != http.StatusBadRequest {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, status, http.StatusBadRequest)
+		}
+	}
+	if !apiServer.WorkSpace.Exists(apiServer.WorkSpace.SecretPath()) {
+		t.Errorf("secret.json should not be removed")
+	}
+}
@@ -1,27 +1,192 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monochromegane/afa/internal/payload"
+	"github.com/monochromegane/afa/internal/protocol"
 )
 
+// Server serves a session on a Unix domain socket.
+// Output of the session is broadcast to all attached viewers,
+// and input is accepted only from the viewer that holds it.
 type Server struct {
 	Addr     string
 	Listener net.Listener
+	// KeepAlive keeps the input open after the last viewer has gone, so that the session waits for another viewer.
+	KeepAlive bool
+
+	mu           sync.Mutex
+	viewers      []*viewerConn
+	writers      sync.WaitGroup
+	holder       *viewerConn
+	info         *protocol.SessionInfo
+	backlog      []*protocol.Event
+	backlogBytes int
+	chunks       strings.Builder
+	closed       bool
+	attached     chan struct{}
+	attachedOnce sync.Once
+	inputR       *io.PipeReader
+	inputW       *io.PipeWriter
+}
+
+// handshakeTimeout is how long the server waits for the hello event from a viewer.
+// A viewer that sends nothing is taken as a legacy viewer, which predates the event protocol.
+const handshakeTimeout = time.Second
+
+// Events to a viewer are queued, so that a slow viewer does not block the session or the other viewers.
+// A viewer whose queue is full, or that does not receive an event in viewerWriteTimeout, is dropped.
+const (
+	viewerQueueSize    = 256
+	viewerWriteTimeout = 10 * time.Second
+)
+
+// The backlog replayed to late viewers keeps only the latest events up to maxBacklogBytes,
+// so that a long session does not grow in memory. Merged chunks are split at a quarter of it.
+const maxBacklogBytes = 1 << 20
+
+// Legacy viewers receive gob encoded byte slices, and these markers in place of events.
+const (
+	legacyPromptMarker = "__AFA_PROMPT__"
+	legacyErrorMarker  = "__AFA_ERROR__"
+)
+
+type viewerConn struct {
+	conn   net.Conn
+	reader io.Reader
+	queue  chan *protocol.Event
+	closed bool
+
+	legacy  bool
+	encoder *gob.Encoder
+	decoder *gob.Decoder
+}
+
+// acceptViewer negotiates the protocol with the viewer, falling back to the legacy one when it sends no hello.
+func acceptViewer(conn net.Conn) (*viewerConn, error) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	if _, err := reader.Peek(1); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return &viewerConn{
+				conn:    conn,
+				reader:  reader,
+				legacy:  true,
+				queue:   make(chan *protocol.Event, viewerQueueSize),
+				encoder: gob.NewEncoder(conn),
+				decoder: gob.NewDecoder(reader),
+			}, nil
+		}
+		return nil, err
+	}
+	if _, err := protocol.AcceptHandshake(struct {
+		io.Reader
+		io.Writer
+	}{reader, conn}); err != nil {
+		return nil, err
+	}
+	return &viewerConn{conn: conn, reader: reader, queue: make(chan *protocol.Event, viewerQueueSize)}, nil
+}
+
+// send queues the event, and reports false when the queue is full or closed.
+// It must be called with the lock of the server held, as well as close.
+func (v *viewerConn) send(event *protocol.Event) bool {
+	if v.closed {
+		return false
+	}
+	select {
+	case v.queue <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// close ends the queue. The events queued so far are still written.
+func (v *viewerConn) close() {
+	if !v.closed {
+		v.closed = true
+		close(v.queue)
+	}
+}
+
+// run writes the events to replay, and then the queued events until the queue is closed.
+func (v *viewerConn) run(replay []*protocol.Event) {
+	defer v.conn.Close()
+	for _, event := range replay {
+		if err := v.writeEvent(event); err != nil {
+			return
+		}
+	}
+	for event := range v.queue {
+		if err := v.writeEvent(event); err != nil {
+			return
+		}
+	}
+}
+
+func (v *viewerConn) writeEvent(event *protocol.Event) error {
+	v.conn.SetWriteDeadline(time.Now().Add(viewerWriteTimeout))
+	if !v.legacy {
+		return protocol.WriteEvent(v.conn, event)
+	}
+	switch event.Type {
+	case protocol.EventChunk:
+		return v.encoder.Encode([]byte(event.Content))
+	case protocol.EventPrompt:
+		return v.encoder.Encode([]byte(legacyPromptMarker))
+	case protocol.EventError:
+		return v.encoder.Encode([]byte(legacyErrorMarker))
+	}
+	return nil
+}
+
+func (v *viewerConn) readEvent() (*protocol.Event, error) {
+	if !v.legacy {
+		return protocol.ReadEvent(v.reader)
+	}
+	var data []byte
+	if err := v.decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	return &protocol.Event{Type: protocol.EventInput, Content: string(data)}, nil
 }
 
 func NewServer(path string) (*Server, error) {
-	if _, err := os.Stat(path); !os.IsNotExist(err) {
+	if _, err := os.Stat(path); err == nil {
+		// A socket that no one listens on is left by a process that has crashed.
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: the socket is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
 		return nil, err
 	}
 
+	inputR, inputW := io.Pipe()
 	return &Server{
-		Addr: path,
+		Addr:     path,
+		attached: make(chan struct{}),
+		inputR:   inputR,
+		inputW:   inputW,
 	}, nil
 }
 
@@ -34,112 +199,351 @@ func (s *Server) Listen() error {
 	return nil
 }
 
-func (s *Server) Accept() (net.Conn, error) {
-	defer s.Listener.Close()
-	return s.Listener.Accept()
+// Serve accepts viewers until the server is disconnected.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
 }
 
-type Client struct {
-	Commands []string
-	cmd      *exec.Cmd
+// Attached is closed when the first viewer is attached.
+func (s *Server) Attached() <-chan struct{} {
+	return s.attached
 }
 
-func NewClient(path string, commands []string) (*Client, error) {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil, err
-	}
-	absolutePath, err := filepath.Abs(path)
+func (s *Server) handle(conn net.Conn) {
+	viewer, err := acceptViewer(conn)
 	if err != nil {
-		return nil, err
+		conn.Close()
+		return
 	}
 
-	return &Client{
-		Commands: append(commands, absolutePath),
-	}, nil
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	// Replay the session so far, so that late viewers can catch up.
+	events := []*protocol.Event{}
+	if s.info != nil {
+		events = append(events, &protocol.Event{Type: protocol.EventSessionInfo, Session: s.info})
+	}
+	s.flushChunks()
+	events = append(events, s.backlog...)
+	if s.holder == nil {
+		s.holder = viewer
+		events = append(events, &protocol.Event{Type: protocol.EventInputControl, Granted: true})
+	} else {
+		events = append(events, &protocol.Event{Type: protocol.EventInputControl, Granted: false})
+	}
+	s.viewers = append(s.viewers, viewer)
+	s.writers.Add(1)
+	go func() {
+		defer s.writers.Done()
+		viewer.run(events)
+	}()
+	s.attachedOnce.Do(func() { close(s.attached) })
+	s.mu.Unlock()
+
+	for {
+		event, err := viewer.readEvent()
+		if err != nil {
+			break
+		}
+		if event.Type != protocol.EventInput {
+			continue
+		}
+		s.mu.Lock()
+		isHolder := s.holder == viewer
+		if !isHolder {
+			viewer.send(&protocol.Event{Type: protocol.EventError, Message: "This viewer is read-only. Another viewer holds the input."})
+		}
+		s.mu.Unlock()
+		if !isHolder {
+			continue
+		}
+		content := event.Content
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		if _, err := s.inputW.Write([]byte(content)); err != nil {
+			break
+		}
+	}
+	s.detach(viewer)
 }
 
-func (c *Client) Start() error {
-	c.cmd = exec.Command(c.Commands[0], c.Commands[1:]...)
-	c.cmd.Stdin = os.Stdin
-	c.cmd.Stdout = os.Stdout
-	return c.cmd.Start()
+func (s *Server) detach(viewer *viewerConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	viewer.conn.Close()
+	viewer.close()
+	for i, v := range s.viewers {
+		if v == viewer {
+			s.viewers = append(s.viewers[:i], s.viewers[i+1:]...)
+			break
+		}
+	}
+	if s.holder != viewer {
+		return
+	}
+	s.holder = nil
+	if len(s.viewers) == 0 {
+		// The session ends when the last viewer that could give input has gone.
+		if !s.KeepAlive {
+			s.inputW.Close()
+		}
+		return
+	}
+	s.holder = s.viewers[0]
+	s.holder.send(&protocol.Event{Type: protocol.EventInputControl, Granted: true})
 }
 
-func (c *Client) Wait() error {
-	if c.cmd == nil {
+func (s *Server) Read(p []byte) (int, error) {
+	return s.inputR.Read(p)
+}
+
+func (s *Server) Write(p []byte) (int, error) {
+	if err := s.broadcast(&protocol.Event{Type: protocol.EventChunk, Content: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Server) Disconnect() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
 		return nil
 	}
-	return c.cmd.Wait()
+	s.closed = true
+	// The viewers are disconnected after they have received the queued events.
+	for _, viewer := range s.viewers {
+		viewer.close()
+	}
+	s.viewers = nil
+	s.inputW.Close()
+	s.mu.Unlock()
+
+	s.writers.Wait()
+	if s.Listener != nil {
+		return s.Listener.Close()
+	}
+	return nil
+}
+
+func (s *Server) Prompt() error {
+	return s.broadcast(&protocol.Event{Type: protocol.EventPrompt})
+}
+
+func (s *Server) Error(err error) error {
+	return s.broadcast(&protocol.Event{Type: protocol.EventError, Message: err.Error()})
+}
+
+func (s *Server) MessageStart(role string) error {
+	return s.broadcast(&protocol.Event{Type: protocol.EventMessageStart, Role: role})
+}
+
+func (s *Server) MessageEnd(role string) error {
+	return s.broadcast(&protocol.Event{Type: protocol.EventMessageEnd, Role: role})
 }
 
-type SocketMessageReader struct {
-	Conn    net.Conn
-	Decoder *gob.Decoder
-	buffer  []byte
-	offset  int
+func (s *Server) Usage(usage *payload.Usage) error {
+	return s.broadcast(&protocol.Event{Type: protocol.EventUsage, Usage: usage})
 }
 
-func NewSocketMessageReader(conn net.Conn) *SocketMessageReader {
-	return &SocketMessageReader{
-		Conn:    conn,
-		Decoder: gob.NewDecoder(conn),
+func (s *Server) SessionInfo(info *protocol.SessionInfo) error {
+	s.mu.Lock()
+	s.info = info
+	s.mu.Unlock()
+	return s.broadcast(&protocol.Event{Type: protocol.EventSessionInfo, Session: info})
+}
+
+func (s *Server) broadcast(event *protocol.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if event.Type != protocol.EventSessionInfo {
+		s.appendBacklog(event)
 	}
+	alive := s.viewers[:0]
+	for _, viewer := range s.viewers {
+		// A viewer that can not keep up is dropped instead of failing the session.
+		if !viewer.send(event) {
+			viewer.conn.Close()
+			viewer.close()
+			continue
+		}
+		alive = append(alive, viewer)
+	}
+	s.viewers = alive
+	return nil
 }
 
-func (r *SocketMessageReader) Read(p []byte) (int, error) {
-	var err error
-	if r.offset >= len(r.buffer) {
-		var data []byte
-		if err = r.Decoder.Decode(&data); err != nil {
-			return 0, nil
+// appendBacklog must be called with the lock held. Consecutive chunks are merged into one.
+func (s *Server) appendBacklog(event *protocol.Event) {
+	if event.Type == protocol.EventChunk {
+		s.chunks.WriteString(event.Content)
+		if s.chunks.Len() >= maxBacklogBytes/4 {
+			s.flushChunks()
 		}
-		r.buffer = data
-		r.offset = 0
+		return
 	}
+	s.flushChunks()
+	s.pushBacklog(event)
+}
 
-	n := copy(p, r.buffer[r.offset:])
-	r.offset += n
+func (s *Server) flushChunks() {
+	if s.chunks.Len() > 0 {
+		s.pushBacklog(&protocol.Event{Type: protocol.EventChunk, Content: s.chunks.String()})
+		s.chunks.Reset()
+	}
+}
 
-	if r.offset >= len(r.buffer) && err == io.EOF {
-		return n, io.EOF
+// pushBacklog drops the oldest events beyond maxBacklogBytes, but keeps the latest one.
+func (s *Server) pushBacklog(event *protocol.Event) {
+	s.backlog = append(s.backlog, event)
+	s.backlogBytes += backlogSize(event)
+	n := 0
+	for s.backlogBytes > maxBacklogBytes && n < len(s.backlog)-1 {
+		s.backlogBytes -= backlogSize(s.backlog[n])
+		s.backlog[n] = nil
+		n++
 	}
-	return n, nil
+	s.backlog = s.backlog[n:]
 }
 
-type SocketMessageWriter struct {
-	Conn    net.Conn
-	Encoder *gob.Encoder
+// backlogSize approximates the memory of an event.
+func backlogSize(event *protocol.Event) int {
+	return len(event.Content) + len(event.Message) + 64
 }
 
-func NewSocketMessageWriter(conn net.Conn) *SocketMessageWriter {
-	return &SocketMessageWriter{
-		Conn:    conn,
-		Encoder: gob.NewEncoder(conn),
-	}
+// Viewer displays the session served on the socket.
+type Viewer interface {
+	// Done is closed when the viewer has finished.
+	Done() <-chan struct{}
+	Wait() error
 }
 
-func (w *SocketMessageWriter) Write(p []byte) (int, error) {
-	buf := bytes.NewBuffer(p)
-	if err := w.Encoder.Encode(buf.Bytes()); err != nil {
-		return 0, err
-	}
-	return len(p), nil
+type Client struct {
+	Commands []string
+	cmd      *exec.Cmd
+	done     chan struct{}
+	err      error
 }
 
-func (w *SocketMessageWriter) Disconnect() error {
-	return w.Conn.Close()
+func NewClient(path string, commands []string) (*Client, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, err
+	}
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		Commands: append(commands, absolutePath),
+	}, nil
 }
 
-func (w *SocketMessageWriter) Prompt() error {
-	if _, err := w.Write([]byte("__AFA_PROMPT__")); err != nil {
+func (c *Client) Start() error {
+	c.cmd = exec.Command(c.Commands[0], c.Commands[1:]...)
+	c.cmd.Stdin = os.Stdin
+	c.cmd.Stdout = os.Stdout
+	if err := c.cmd.Start(); err != nil {
 		return err
 	}
+	c.done = make(chan struct{})
+	go func() {
+		c.err = c.cmd.Wait()
+		close(c.done)
+	}()
 	return nil
 }
 
-func (w *SocketMessageWriter) Error() error {
-	if _, err := w.Write([]byte("__AFA_ERROR__")); err != nil {
+// Done is closed when the viewer process exits.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) Wait() error {
+	if c.cmd == nil {
+		return nil
+	}
+	<-c.done
+	return c.err
+}
+
+// TerminalViewer is a minimal viewer that attaches to a session socket from the terminal.
+type TerminalViewer struct {
+	Input  io.Reader
+	Output io.Writer
+}
+
+func (v *TerminalViewer) Attach(path string) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
 		return err
 	}
-	return nil
+	defer conn.Close()
+	if _, err := protocol.Handshake(conn); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	granted := false
+	go func() {
+		scanner := bufio.NewScanner(v.Input)
+		for scanner.Scan() {
+			mu.Lock()
+			canInput := granted
+			mu.Unlock()
+			if !canInput {
+				fmt.Fprintln(v.Output, "(read-only) Another viewer holds the input.")
+				continue
+			}
+			if err := protocol.WriteEvent(conn, &protocol.Event{Type: protocol.EventInput, Content: scanner.Text()}); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		event, err := protocol.ReadEvent(conn)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch event.Type {
+		case protocol.EventSessionInfo:
+			fmt.Fprintf(v.Output, "Attached to %s (%s)\n", event.Session.Name, event.Session.Model)
+		case protocol.EventChunk:
+			fmt.Fprint(v.Output, event.Content)
+		case protocol.EventPrompt:
+			mu.Lock()
+			canInput := granted
+			mu.Unlock()
+			if canInput {
+				fmt.Fprint(v.Output, "> ")
+			}
+		case protocol.EventError:
+			fmt.Fprintf(v.Output, "Error: %s\n", event.Message)
+		case protocol.EventInputControl:
+			mu.Lock()
+			granted = event.Granted
+			mu.Unlock()
+		}
+	}
 }
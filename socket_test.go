@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monochromegane/afa/internal/protocol"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
+
+func TestServerBroadcastsToViewers(t *testing.T) {
+	dir, err := os.MkdirTemp("", "afa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := NewServer(filepath.Join(dir, "session.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	defer server.Disconnect()
+
+	// The first viewer holds the input.
+	holderInput, holderInputW := io.Pipe()
+	holderOutput := &syncBuffer{}
+	go (&TerminalViewer{Input: holderInput, Output: holderOutput}).Attach(server.Addr)
+	<-server.Attached()
+	if _, err := server.Write([]byte("early output\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second viewer catches up with the output so far.
+	observerInput, observerInputW := io.Pipe()
+	observerOutput := &syncBuffer{}
+	go (&TerminalViewer{Input: observerInput, Output: observerOutput}).Attach(server.Addr)
+	waitFor(t, func() bool { return strings.Contains(observerOutput.String(), "early output") })
+
+	server.Write([]byte("late output\n"))
+	waitFor(t, func() bool {
+		return strings.Contains(holderOutput.String(), "late output") && strings.Contains(observerOutput.String(), "late output")
+	})
+
+	observerInputW.Write([]byte("from observer\n"))
+	waitFor(t, func() bool { return strings.Contains(observerOutput.String(), "read-only") })
+
+	holderInputW.Write([]byte("from holder\n"))
+	scanner := bufio.NewScanner(server)
+	if !scanner.Scan() || scanner.Text() != "from holder" {
+		t.Errorf("Server should read input from the holder, but got %q", scanner.Text())
+	}
+}
+
+func TestServerAcceptsLegacyViewer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "afa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := NewServer(filepath.Join(dir, "session.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	defer server.Disconnect()
+
+	// A legacy viewer sends no hello and exchanges gob encoded bytes.
+	conn, err := net.Dial("unix", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case <-server.Attached():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Legacy viewer should be attached after the handshake timeout")
+	}
+
+	server.Write([]byte("output"))
+	server.Prompt()
+	decoder := gob.NewDecoder(conn)
+	for _, want := range []string{"output", legacyPromptMarker} {
+		var data []byte
+		if err := decoder.Decode(&data); err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("Legacy viewer should receive %q, but got %q", want, data)
+		}
+	}
+
+	if err := gob.NewEncoder(conn).Encode([]byte("input")); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(server)
+	if !scanner.Scan() || scanner.Text() != "input" {
+		t.Errorf("Server should read input from the legacy viewer, but got %q", scanner.Text())
+	}
+}
+
+func TestServerDropsSlowViewer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "afa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := NewServer(filepath.Join(dir, "session.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	defer server.Disconnect()
+
+	// The viewer never reads the output.
+	conn, err := net.Dial("unix", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := protocol.Handshake(conn); err != nil {
+		t.Fatal(err)
+	}
+	<-server.Attached()
+
+	done := make(chan struct{})
+	go func() {
+		chunk := []byte(strings.Repeat("x", 64*1024))
+		for i := 0; i < 2*viewerQueueSize; i++ {
+			server.Write(chunk)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Slow viewer should not block the session")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.viewers) != 0 {
+		t.Errorf("Slow viewer should be dropped")
+	}
+	// Consecutive chunks are merged, and only the latest of them are kept.
+	server.flushChunks()
+	if len(server.backlog) != 3 || server.backlogBytes > maxBacklogBytes {
+		t.Errorf("backlog = %d events of %d bytes, want 3 events up to %d bytes", len(server.backlog), server.backlogBytes, maxBacklogBytes)
+	}
+	for _, event := range server.backlog {
+		if len(event.Content) != maxBacklogBytes/4 {
+			t.Errorf("merged chunk = %d bytes, want %d", len(event.Content), maxBacklogBytes/4)
+		}
+	}
+}
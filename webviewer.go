@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/monochromegane/afa/internal/protocol"
+)
+
+//go:embed web/index.html
+var webViewerPage []byte
+
+// webViewerTimeout is how long the viewer keeps serving after the session has ended, waiting for a browser to show the end of it.
+const webViewerTimeout = 30 * time.Second
+
+// WebViewer is a viewer that attaches to a session socket and serves it to the browser.
+// Events are streamed to the page as server-sent events, and input is posted back to the session.
+type WebViewer struct {
+	Addr   string
+	Output io.Writer
+	// Timeout is how long to keep serving after the session has ended when no browser receives the end of it.
+	Timeout time.Duration
+
+	token    string
+	listener net.Listener
+	server   *http.Server
+	conn     net.Conn
+	writeMu  sync.Mutex
+
+	mu          sync.Mutex
+	backlog     []*protocol.Event
+	subscribers map[chan *protocol.Event]struct{}
+	granted     bool
+	ended       bool
+	delivered   chan struct{}
+	once        sync.Once
+	done        chan struct{}
+	err         error
+}
+
+func NewWebViewer(addr string, output io.Writer) *WebViewer {
+	return &WebViewer{
+		Addr:        addr,
+		Output:      output,
+		Timeout:     webViewerTimeout,
+		subscribers: map[chan *protocol.Event]struct{}{},
+		delivered:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (v *WebViewer) Start(path string) error {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	v.token = hex.EncodeToString(token)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return err
+	}
+	if _, err := protocol.Handshake(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	v.conn = conn
+
+	listener, err := net.Listen("tcp", v.Addr)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	v.listener = listener
+	v.server = &http.Server{Handler: v.Handler()}
+	go v.server.Serve(listener)
+
+	fmt.Fprintf(v.Output, "Open %s to view the session.\n", v.URL())
+
+	go v.receive()
+	return nil
+}
+
+func (v *WebViewer) URL() string {
+	return fmt.Sprintf("http://%s/?token=%s", v.listener.Addr(), v.token)
+}
+
+func (v *WebViewer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", v.page)
+	mux.HandleFunc("GET /events", v.events)
+	mux.HandleFunc("POST /input", v.input)
+	return mux
+}
+
+// Done is closed when the session has ended and a browser has received the end of it.
+func (v *WebViewer) Done() <-chan struct{} {
+	return v.done
+}
+
+func (v *WebViewer) Wait() error {
+	<-v.done
+	return v.err
+}
+
+func (v *WebViewer) receive() {
+	for {
+		event, err := protocol.ReadEvent(v.conn)
+		if err != nil {
+			if err != io.EOF {
+				v.err = err
+			}
+			break
+		}
+		v.mu.Lock()
+		if event.Type == protocol.EventInputControl {
+			v.granted = event.Granted
+		}
+		v.publish(event)
+		v.mu.Unlock()
+	}
+
+	v.mu.Lock()
+	v.ended = true
+	v.granted = false
+	v.publish(&protocol.Event{Type: sseEventDone})
+	for ch := range v.subscribers {
+		delete(v.subscribers, ch)
+		close(ch)
+	}
+	v.mu.Unlock()
+	v.conn.Close()
+
+	// Keep serving until the page has shown the whole session, or no browser has come in time.
+	select {
+	case <-v.delivered:
+	case <-time.After(v.Timeout):
+	}
+	v.server.Close()
+	close(v.done)
+}
+
+// publish must be called with the lock held.
+func (v *WebViewer) publish(event *protocol.Event) {
+	v.backlog = append(v.backlog, event)
+	for ch := range v.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// A slow page is dropped; it reconnects and replays the backlog.
+			delete(v.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (v *WebViewer) authorized(r *http.Request) bool {
+	token := r.Header.Get("X-Afa-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(v.token)) == 1
+}
+
+func (v *WebViewer) page(w http.ResponseWriter, r *http.Request) {
+	if !v.authorized(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(webViewerPage)
+}
+
+func (v *WebViewer) events(w http.ResponseWriter, r *http.Request) {
+	if !v.authorized(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	sse, err := NewSSEMessageWriter(w)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	v.mu.Lock()
+	backlog := append([]*protocol.Event{}, v.backlog...)
+	ch := make(chan *protocol.Event, 256)
+	if !v.ended {
+		v.subscribers[ch] = struct{}{}
+	} else {
+		close(ch)
+	}
+	v.mu.Unlock()
+	defer func() {
+		v.mu.Lock()
+		if _, ok := v.subscribers[ch]; ok {
+			delete(v.subscribers, ch)
+			close(ch)
+		}
+		v.mu.Unlock()
+	}()
+
+	for _, event := range backlog {
+		if err := v.send(sse, event); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := v.send(sse, event); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (v *WebViewer) send(sse *SSEMessageWriter, event *protocol.Event) error {
+	if err := sse.writeEvent(event); err != nil {
+		return err
+	}
+	if event.Type == sseEventDone {
+		v.once.Do(func() { close(v.delivered) })
+	}
+	return nil
+}
+
+func (v *WebViewer) input(w http.ResponseWriter, r *http.Request) {
+	if !v.authorized(r) {
+		writeJSONError(w, http.StatusForbidden, fmt.Errorf("Invalid token."))
+		return
+	}
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	v.mu.Lock()
+	granted := v.granted
+	v.mu.Unlock()
+	if !granted {
+		writeJSONError(w, http.StatusConflict, fmt.Errorf("This viewer is read-only. Another viewer holds the input."))
+		return
+	}
+
+	v.writeMu.Lock()
+	err := protocol.WriteEvent(v.conn, &protocol.Event{Type: protocol.EventInput, Content: body.Content})
+	v.writeMu.Unlock()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestWebViewer(t *testing.T) (*Server, *WebViewer) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "afa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	server, err := NewServer(filepath.Join(dir, "session.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	viewer := NewWebViewer("127.0.0.1:0", &syncBuffer{})
+	viewer.Timeout = 100 * time.Millisecond
+	if err := viewer.Start(server.Addr); err != nil {
+		server.Disconnect()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Disconnect()
+		viewer.Wait()
+	})
+	<-server.Attached()
+	return server, viewer
+}
+
+func TestWebViewerRequiresToken(t *testing.T) {
+	_, viewer := newTestWebViewer(t)
+	base := "http://" + viewer.listener.Addr().String()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		want   int
+	}{
+		{name: "page without token", method: http.MethodGet, path: "/", want: http.StatusForbidden},
+		{name: "page with wrong token", method: http.MethodGet, path: "/?token=wrong", want: http.StatusForbidden},
+		{name: "page with token", method: http.MethodGet, path: "/?token=" + viewer.token, want: http.StatusOK},
+		{name: "events without token", method: http.MethodGet, path: "/events", want: http.StatusForbidden},
+		{name: "input without token", method: http.MethodPost, path: "/input", want: http.StatusForbidden},
+		{name: "input with wrong header", method: http.MethodPost, path: "/input", header: "wrong", want: http.StatusForbidden},
+		{name: "input with header", method: http.MethodPost, path: "/input", header: viewer.token, want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, base+tt.path, strings.NewReader(`{"content":"hi"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Afa-Token", tt.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebViewerShutsDownWithoutBrowser(t *testing.T) {
+	server, viewer := newTestWebViewer(t)
+	addr := viewer.listener.Addr().String()
+
+	server.Disconnect()
+	select {
+	case <-viewer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the viewer should shut down after the timeout when no browser receives the end of the session")
+	}
+	if _, err := http.Get("http://" + addr + "/?token=" + viewer.token); err == nil {
+		t.Errorf("the viewer should stop serving after shutting down")
+	}
+}